@@ -0,0 +1,27 @@
+package main
+
+// Backend is the key/value store a World keeps its state in. Keys within a
+// collection are ordered bytewise.
+type Backend interface {
+	// Collection returns the named collection, creating it if it does not
+	// exist yet.
+	Collection(name string) Collection
+	CollectionNames() []string
+
+	// Flush makes every change since the last Flush durable. Backends that
+	// do not persist anything can return nil.
+	Flush() error
+	Close() error
+}
+
+// Collection is one named, ordered set of keys within a Backend. Get returns
+// a nil value and a nil error for a missing key.
+type Collection interface {
+	Get(key []byte) ([]byte, error)
+	Set(key, value []byte) error
+	Delete(key []byte) error
+
+	// Visit calls f for each item whose key is at least start, in ascending
+	// key order, until f returns false. f must not modify the collection.
+	Visit(start []byte, f func(key, value []byte) bool) error
+}
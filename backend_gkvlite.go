@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/steveyen/gkvlite"
+	"os"
+)
+
+type gkvliteBackend struct {
+	file  *os.File
+	store *gkvlite.Store
+}
+
+// NewGkvliteBackend opens the gkvlite store in f. The backend takes
+// ownership of f and closes it in Close.
+func NewGkvliteBackend(f *os.File) (Backend, error) {
+	store, err := gkvlite.NewStore(f)
+	if err != nil {
+		return nil, err
+	}
+	return &gkvliteBackend{file: f, store: store}, nil
+}
+
+// File returns the file the store lives in.
+func (b *gkvliteBackend) File() *os.File {
+	return b.file
+}
+
+func (b *gkvliteBackend) Collection(name string) Collection {
+	c := b.store.GetCollection(name)
+	if c == nil {
+		c = b.store.SetCollection(name, nil)
+	}
+	return gkvliteCollection{c}
+}
+
+func (b *gkvliteBackend) CollectionNames() []string {
+	return b.store.GetCollectionNames()
+}
+
+func (b *gkvliteBackend) Flush() error {
+	// gkvlite only appends to the file and writes the new root last, so
+	// a crash during Flush leaves the previous state intact.
+	if err := b.store.Flush(); err != nil {
+		return err
+	}
+	return b.file.Sync()
+}
+
+func (b *gkvliteBackend) Close() error {
+	b.store.Close()
+	return b.file.Close()
+}
+
+type gkvliteCollection struct {
+	*gkvlite.Collection
+}
+
+func (c gkvliteCollection) Delete(key []byte) error {
+	_, err := c.Collection.Delete(key)
+	return err
+}
+
+func (c gkvliteCollection) Visit(start []byte, f func(key, value []byte) bool) error {
+	return c.Collection.VisitItemsAscend(start, true, func(i *gkvlite.Item) bool {
+		return f(i.Key, i.Val)
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+type memoryBackend struct {
+	collections map[string]*memoryCollection
+	mtx         sync.Mutex
+}
+
+// NewMemoryBackend returns a Backend that keeps everything in memory. It is
+// meant for tools and headless simulations that should not touch the disk.
+func NewMemoryBackend() Backend {
+	return &memoryBackend{collections: make(map[string]*memoryCollection)}
+}
+
+func (b *memoryBackend) Collection(name string) Collection {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	c := b.collections[name]
+	if c == nil {
+		c = &memoryCollection{items: make(map[string][]byte)}
+		b.collections[name] = c
+	}
+	return c
+}
+
+func (b *memoryBackend) CollectionNames() []string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	names := make([]string, 0, len(b.collections))
+	for name := range b.collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (b *memoryBackend) Flush() error {
+	return nil
+}
+
+func (b *memoryBackend) Close() error {
+	return nil
+}
+
+type memoryCollection struct {
+	items map[string][]byte
+	keys  []string // sorted; nil when it needs to be rebuilt
+	mtx   sync.RWMutex
+}
+
+func (c *memoryCollection) Get(key []byte) ([]byte, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	v, ok := c.items[string(key)]
+	if !ok {
+		return nil, nil
+	}
+	return cloneBytes(v), nil
+}
+
+func (c *memoryCollection) Set(key, value []byte) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if _, ok := c.items[string(key)]; !ok {
+		c.keys = nil
+	}
+	c.items[string(key)] = cloneBytes(value)
+	return nil
+}
+
+func (c *memoryCollection) Delete(key []byte) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if _, ok := c.items[string(key)]; ok {
+		delete(c.items, string(key))
+		c.keys = nil
+	}
+	return nil
+}
+
+func (c *memoryCollection) Visit(start []byte, f func(key, value []byte) bool) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.keys == nil {
+		c.keys = make([]string, 0, len(c.items))
+		for k := range c.items {
+			c.keys = append(c.keys, k)
+		}
+		sort.Strings(c.keys)
+	}
+
+	for i := sort.SearchStrings(c.keys, string(start)); i < len(c.keys); i++ {
+		if !f([]byte(c.keys[i]), cloneBytes(c.items[c.keys[i]])) {
+			break
+		}
+	}
+	return nil
+}
+
+// cloneBytes copies b. Unlike append to a nil slice, it keeps an empty value
+// distinct from a missing one, which Get reports as nil.
+func cloneBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
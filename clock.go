@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+var ticksPerFrame = flag.Uint64("ticks-per-frame", 4, "game ticks per frame at normal speed, out of 65535 in a day")
+
+// gameSpeeds are the multipliers of ticksPerFrame the speed keys choose
+// between. The first is normal speed.
+var gameSpeeds = [...]uint64{1, 5, 25, 100}
+
+// clock is how fast game time passes in this session. It is not saved;
+// loaded worlds start at normal speed.
+type clock struct {
+	paused bool
+	speed  uint64 // one of gameSpeeds
+}
+
+// Frame advances the world by however many ticks a frame is at the
+// current speed.
+func (w *World) Frame() {
+	w.Lock()
+	ticks := uint64(0)
+	if !w.clock.paused {
+		ticks = *ticksPerFrame * w.clock.speed
+	}
+	w.Unlock()
+
+	for i := uint64(0); i < ticks; i++ {
+		w.Tick()
+	}
+}
+
+// Tick advances the world clock by one tick, runs the scheduled events that
+// are due, and then runs the systems due on the new tick. Time stops at
+// ts_max.
+func (w *World) Tick() {
+	w.Lock()
+	now, ok := w.Time().Add(1)
+	if w.Time() == 0 {
+		now, ok = ts_min, true
+	}
+	if ok {
+		w.setTime(now)
+	}
+	w.Unlock()
+	if !ok {
+		return
+	}
+
+	w.runSchedule(now)
+	w.runSystems(now)
+}
+
+// SetSpeed unpauses the game and runs it at multiplier times normal speed.
+func (w *World) SetSpeed(multiplier uint64) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.clock.paused = false
+	w.clock.speed = multiplier
+}
+
+// TogglePause pauses the game or resumes it at the speed it was at.
+func (w *World) TogglePause() {
+	w.Lock()
+	defer w.Unlock()
+
+	w.clock.paused = !w.clock.paused
+}
+
+// Speed returns the speed multiplier and whether the game is paused.
+func (w *World) Speed() (multiplier uint64, paused bool) {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.clock.speed, w.clock.paused
+}
+
+// SpeedText describes the speed for the border.
+func (w *World) SpeedText() string {
+	multiplier, paused := w.Speed()
+	if paused {
+		return "paused"
+	}
+	return fmt.Sprintf("%dx", multiplier)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A command runs instead of the game when its name is the first argument on
+// the command line, as in "untitled-game export mysave mysave-export".
+type command struct {
+	Usage string
+	Run   func(args []string) error
+}
+
+var commands = make(map[string]command)
+
+func registerCommand(name, usage string, run func(args []string) error) {
+	if _, ok := commands[name]; ok {
+		panic(fmt.Sprintf("duplicate command %q", name))
+	}
+	commands[name] = command{Usage: usage, Run: run}
+}
+
+// errUsage is returned by a command's Run function when it was given the
+// wrong arguments.
+var errUsage = errors.New("usage")
+
+func runCommand(args []string) {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q; commands are:\n", args[0])
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(os.Stderr, "\t%s %s %s\n", os.Args[0], name, commands[name].Usage)
+		}
+		os.Exit(2)
+	}
+
+	err := cmd.Run(args[1:])
+	if err == errUsage {
+		fmt.Fprintf(os.Stderr, "usage: %s %s %s\n", os.Args[0], args[0], cmd.Usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+}
+
+// savePath returns the file a command's save argument refers to: either the
+// name of a save in SaveDirName, as shown in the main menu, or a path to a
+// .sav file.
+func savePath(name string) string {
+	if strings.HasSuffix(name, ".sav") {
+		return name
+	}
+	return saveFileName(name)
+}
+
+// openSave opens an existing save for a command that only reads it. The save
+// is copied into memory and migrated there, like it would be by the main
+// menu, so the file itself is never written. Anything loading leaves in the
+// World caches is written to the copy, so the command can read the store
+// directly.
+func openSave(name string) (w *World, err error) {
+	f, err := os.Open(savePath(name))
+	if err != nil {
+		return
+	}
+	src, err := NewGkvliteBackend(f)
+	if err != nil {
+		f.Close()
+		return
+	}
+	defer src.Close()
+
+	store := NewMemoryBackend()
+	if err = copyBackend(store, src); err != nil {
+		return
+	}
+	if w, err = LoadWorld(store); err != nil {
+		return
+	}
+
+	w.Lock()
+	err = w.writeCaches()
+	w.Unlock()
+	if err != nil {
+		w = nil
+	}
+	return
+}
@@ -1,14 +1,180 @@
 package main
 
 import (
+	"bytes"
 	"encoding/gob"
 	"fmt"
+	"log"
+	"reflect"
 )
 
 type Component interface {
 	fmt.Stringer
 }
 
-func registerComponentType(v Component) {
-	gob.Register(v)
+// ComponentType describes how a component is stored in saves. Components are
+// identified by ID rather than by their Go type name, so they can be renamed
+// or moved without breaking existing saves.
+type ComponentType struct {
+	// ID must never change once the component has been saved. By
+	// convention it is the tag the component's String method starts with.
+	ID string
+
+	// Version must be increased whenever the component's fields change in
+	// a way gob cannot decode into the new struct.
+	Version uint
+
+	// Proto is a pointer to a zero value of the component's type.
+	Proto Component
+
+	// Upgrade decodes data written by an older Version of the component.
+	// It is only called with versions lower than Version.
+	Upgrade func(version uint, data []byte) (Component, error)
+
+	// Single component types can be on an entity at most once.
+	Single bool
+
+	typ reflect.Type
+}
+
+var (
+	componentTypesByID   = make(map[string]*ComponentType)
+	componentTypesByType = make(map[reflect.Type]*ComponentType)
+)
+
+func registerComponentType(t ComponentType) {
+	t.typ = reflect.TypeOf(t.Proto)
+	if t.typ.Kind() != reflect.Ptr || t.typ.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("component %q: Proto must be a pointer to a struct, not %v", t.ID, t.typ))
+	}
+	if t.Version == 0 {
+		panic(fmt.Sprintf("component %q: Version must be at least 1", t.ID))
+	}
+	if other, ok := componentTypesByID[t.ID]; ok {
+		panic(fmt.Sprintf("component %q: ID already used by %v", t.ID, other.typ))
+	}
+	if other, ok := componentTypesByType[t.typ]; ok {
+		panic(fmt.Sprintf("component %q: type %v already registered as %q", t.ID, t.typ, other.ID))
+	}
+
+	componentTypesByID[t.ID] = &t
+	componentTypesByType[t.typ] = &t
+
+	// Version 1 saves stored components by their Go type name. This is
+	// only needed until every save has been migrated.
+	gob.Register(t.Proto)
+}
+
+// componentID returns the ID c is saved under, or "" if c's type is not
+// registered.
+func componentID(c Component) string {
+	if o, ok := c.(*OpaqueComponent); ok {
+		return o.Type
+	}
+	if t, ok := componentTypesByType[reflect.TypeOf(c)]; ok {
+		return t.ID
+	}
+	return ""
+}
+
+// OpaqueComponent holds a saved component whose ID or version this build of
+// the game does not know. It is saved again exactly as it was loaded.
+type OpaqueComponent struct {
+	Type    string
+	Version uint
+	Data    []byte
+}
+
+func (c *OpaqueComponent) String() string {
+	return fmt.Sprintf("OPAQUE type[text]=%q version[int]=%v data[bytes]=%x", c.Type, c.Version, c.Data)
+}
+
+func (c *OpaqueComponent) parseText(f *textFields) error {
+	c.Type = f.Text("type")
+	c.Version = uint(f.Uint("version"))
+	c.Data = f.Bytes("data")
+	return f.Done()
+}
+
+// componentParser is implemented by components that can read back the text
+// their String method writes.
+type componentParser interface {
+	Component
+	parseText(f *textFields) error
+}
+
+// ParseComponent reads one line written by a component's String method.
+// Leading and trailing space is ignored.
+func ParseComponent(line string) (Component, error) {
+	f, err := parseTextFields(line)
+	if err != nil {
+		return nil, err
+	}
+
+	var c componentParser
+	if f.Tag == "OPAQUE" {
+		c = &OpaqueComponent{}
+	} else if t, ok := componentTypesByID[f.Tag]; ok {
+		c, ok = reflect.New(t.typ.Elem()).Interface().(componentParser)
+		if !ok {
+			return nil, fmt.Errorf("component %s cannot be parsed", f.Tag)
+		}
+	} else {
+		return nil, fmt.Errorf("unknown component %s", f.Tag)
+	}
+
+	err = c.parseText(f)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type savedComponent struct {
+	Type    string
+	Version uint
+	Data    []byte
+}
+
+func encodeComponent(c Component) (s savedComponent, err error) {
+	if o, ok := c.(*OpaqueComponent); ok {
+		return savedComponent{Type: o.Type, Version: o.Version, Data: o.Data}, nil
+	}
+
+	t, ok := componentTypesByType[reflect.TypeOf(c)]
+	if !ok {
+		err = fmt.Errorf("unregistered component type %T", c)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(c)
+	if err != nil {
+		return
+	}
+
+	s = savedComponent{Type: t.ID, Version: t.Version, Data: buf.Bytes()}
+	return
+}
+
+func decodeComponent(s savedComponent) (Component, error) {
+	t, ok := componentTypesByID[s.Type]
+	if !ok || s.Version > t.Version {
+		log.Printf("keeping unknown component %s version %d as opaque", s.Type, s.Version)
+		return &OpaqueComponent{Type: s.Type, Version: s.Version, Data: s.Data}, nil
+	}
+
+	if s.Version < t.Version {
+		if t.Upgrade == nil {
+			return nil, fmt.Errorf("component %s: no upgrade from version %d to %d", s.Type, s.Version, t.Version)
+		}
+		return t.Upgrade(s.Version, s.Data)
+	}
+
+	v := reflect.New(t.typ.Elem())
+	err := gob.NewDecoder(bytes.NewReader(s.Data)).Decode(v.Interface())
+	if err != nil {
+		return nil, fmt.Errorf("component %s: %v", s.Type, err)
+	}
+	return v.Interface().(Component), nil
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"testing/quick"
+)
+
+// TestComponentTextFormat checks that every registered component type
+// survives a trip through its text format, which is how saves are exported
+// and how entities are written by hand.
+func TestComponentTextFormat(t *testing.T) {
+	ids := make([]string, 0, len(componentTypesByID))
+	for id := range componentTypesByID {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		ent := &Entity{ID: EntityReference(r.Uint32()) + 1}
+		for _, id := range ids {
+			v, ok := quick.Value(componentTypesByID[id].typ.Elem(), r)
+			if !ok {
+				t.Fatalf("%s: cannot generate a value", id)
+			}
+			ent.Components = append(ent.Components, v.Addr().Interface().(Component))
+		}
+		v, _ := quick.Value(reflect.TypeOf(OpaqueComponent{}), r)
+		opaque := v.Addr().Interface().(*OpaqueComponent)
+		if opaque.Type == "" {
+			// saved components always have a type.
+			opaque.Type = "UNKNOWN"
+		}
+		ent.Components = append(ent.Components, opaque)
+
+		for _, c := range ent.Components {
+			parsed, err := ParseComponent(c.String())
+			if err != nil {
+				t.Fatalf("%v: %v", c, err)
+			}
+			checkSameComponent(t, c, parsed)
+		}
+
+		parsed, err := ParseEntity(ent.String())
+		if err != nil {
+			t.Fatalf("%v\n%v", ent, err)
+		}
+		if parsed.ID != ent.ID || len(parsed.Components) != len(ent.Components) {
+			t.Fatalf("%v\nparsed as\n%v", ent, parsed)
+		}
+		for i, c := range ent.Components {
+			checkSameComponent(t, c, parsed.Components[i])
+		}
+	}
+}
+
+func TestParseEntityComments(t *testing.T) {
+	ent, err := ParseEntity("# comment\nENTITY id[entity]=3\n\t# another\n\tOWNER_OF id[entity]=4\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ent.ID != 3 || len(ent.Components) != 1 {
+		t.Fatalf("parsed as\n%v", ent)
+	}
+}
+
+// checkSameComponent compares components by how they are saved, so nil and
+// empty slices are the same.
+func checkSameComponent(t *testing.T, a, b Component) {
+	sa, err := encodeComponent(a)
+	if err != nil {
+		t.Fatalf("%v: %v", a, err)
+	}
+	sb, err := encodeComponent(b)
+	if err != nil {
+		t.Fatalf("%v: %v", b, err)
+	}
+	if sa.Type != sb.Type || sa.Version != sb.Version || !bytes.Equal(sa.Data, sb.Data) {
+		t.Fatalf("%v parsed as %v", a, b)
+	}
+}
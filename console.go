@@ -0,0 +1,369 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nsf/termbox-go"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The debug console is a command line drawn over the bottom of the game,
+// opened and closed with the ` key. Type "help" in it for a list of
+// commands.
+type consoleUI struct {
+	open   bool
+	input  []rune
+	output []string
+}
+
+var debugConsole consoleUI
+
+// consoleLines is how much output the console keeps.
+const consoleLines = 100
+
+type consoleCommand struct {
+	Usage string
+	Run   func(w *World, args []string) (string, error)
+}
+
+var consoleCommands = make(map[string]consoleCommand)
+
+func registerConsoleCommand(name, usage string, run func(w *World, args []string) (string, error)) {
+	if _, ok := consoleCommands[name]; ok {
+		panic(fmt.Sprintf("duplicate console command %q", name))
+	}
+	consoleCommands[name] = consoleCommand{Usage: usage, Run: run}
+}
+
+// inputKey handles a key press while the console is open. It returns false
+// when the console closes.
+func (c *consoleUI) inputKey(w *World, key termbox.Key, ch rune) bool {
+	switch {
+	case key == termbox.KeyEsc || ch == '`':
+		c.open = false
+	case key == termbox.KeyEnter:
+		line := strings.TrimSpace(string(c.input))
+		c.input = c.input[:0]
+		if line != "" {
+			c.print("> " + line)
+			c.print(c.run(w, line))
+		}
+	case key == termbox.KeyBackspace || key == termbox.KeyBackspace2:
+		if len(c.input) == 0 {
+			fmt.Print("\a")
+		} else {
+			c.input = c.input[:len(c.input)-1]
+		}
+	case key == termbox.KeySpace:
+		c.input = append(c.input, ' ')
+	case ch != 0:
+		c.input = append(c.input, ch)
+	}
+	return c.open
+}
+
+func (c *consoleUI) print(s string) {
+	if s == "" {
+		return
+	}
+	c.output = append(c.output, strings.Split(s, "\n")...)
+	if len(c.output) > consoleLines {
+		c.output = c.output[len(c.output)-consoleLines:]
+	}
+}
+
+// run runs one line typed into the console and returns what to print.
+func (c *consoleUI) run(w *World, line string) string {
+	args := strings.Fields(line)
+	cmd, ok := consoleCommands[args[0]]
+	if !ok {
+		return fmt.Sprintf("unknown command %q; try help", args[0])
+	}
+
+	out, err := cmd.Run(w, args[1:])
+	if err == errUsage {
+		return "usage: " + args[0] + " " + cmd.Usage
+	}
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return out
+}
+
+// render draws the console over the bottom half of the screen, inside the
+// border.
+func (c *consoleUI) render(w, h int) {
+	rows := h / 2
+	drawLine := func(y int, s string, fg termbox.Attribute) {
+		x := 1
+		for _, ch := range s {
+			if x >= w-1 {
+				break
+			}
+			if ch == '\t' {
+				ch = ' '
+			}
+			termbox.SetCell(x, y, ch, fg, termbox.ColorBlack)
+			x++
+		}
+		for ; x < w-1; x++ {
+			termbox.SetCell(x, y, ' ', fg, termbox.ColorBlack)
+		}
+	}
+
+	inputY := h - 2
+	out := c.output
+	if len(out) > rows-1 {
+		out = out[len(out)-(rows-1):]
+	}
+	for y := inputY - rows + 1; y < inputY; y++ {
+		i := y - (inputY - len(out))
+		if i >= 0 && i < len(out) {
+			drawLine(y, out[i], termbox.ColorWhite)
+		} else {
+			drawLine(y, "", termbox.ColorWhite)
+		}
+	}
+	drawLine(inputY, "> "+string(c.input)+"_", termbox.ColorWhite|termbox.AttrBold)
+}
+
+// parseEntityArg reads an entity ID argument, with or without a leading #.
+func parseEntityArg(s string) (EntityReference, error) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(s, "#"), 10, 64)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("bad entity ID %q", s)
+	}
+	return EntityReference(id), nil
+}
+
+// editEntity calls f with the entity id, which is released afterwards.
+func editEntity(w *World, id string, f func(ent *Entity) (string, error)) (string, error) {
+	ref, err := parseEntityArg(id)
+	if err != nil {
+		return "", err
+	}
+	ent, err := w.RequestEntity(ref)
+	if err != nil {
+		return "", err
+	}
+	defer w.ReleaseEntity(ent)
+
+	return f(ent)
+}
+
+func init() {
+	registerConsoleCommand("help", "", func(w *World, args []string) (string, error) {
+		names := make([]string, 0, len(consoleCommands))
+		for name := range consoleCommands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i, name := range names {
+			names[i] = name + " " + consoleCommands[name].Usage
+		}
+		return strings.Join(names, "\n"), nil
+	})
+
+	registerConsoleCommand("inspect", "<id>", func(w *World, args []string) (string, error) {
+		if len(args) != 1 {
+			return "", errUsage
+		}
+		id, err := parseEntityArg(args[0])
+		if err != nil {
+			return "", err
+		}
+		ent, err := w.RequestEntity(id)
+		if err == ErrEntityDestroyed {
+			tomb, destroyed, err := w.Tombstone(id)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("destroyed at %v:\n%v", destroyed, tomb), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		defer w.ReleaseEntity(ent)
+		return ent.String(), nil
+	})
+
+	registerConsoleCommand("create", "[component]", func(w *World, args []string) (string, error) {
+		var c Component
+		if len(args) != 0 {
+			var err error
+			if c, err = ParseComponent(strings.Join(args, " ")); err != nil {
+				return "", err
+			}
+		}
+		ent, err := w.NewEntity()
+		if err != nil {
+			return "", err
+		}
+		defer w.ReleaseEntity(ent)
+		if c != nil {
+			if err = ent.Add(c); err != nil {
+				return "", err
+			}
+		}
+		return ent.String(), nil
+	})
+
+	registerConsoleCommand("add", "<id> <component>", func(w *World, args []string) (string, error) {
+		if len(args) < 2 {
+			return "", errUsage
+		}
+		c, err := ParseComponent(strings.Join(args[1:], " "))
+		if err != nil {
+			return "", err
+		}
+		return editEntity(w, args[0], func(ent *Entity) (string, error) {
+			if err := ent.Add(c); err != nil {
+				return "", err
+			}
+			return ent.String(), nil
+		})
+	})
+
+	registerConsoleCommand("set", "<id> <component>", func(w *World, args []string) (string, error) {
+		if len(args) < 2 {
+			return "", errUsage
+		}
+		c, err := ParseComponent(strings.Join(args[1:], " "))
+		if err != nil {
+			return "", err
+		}
+		return editEntity(w, args[0], func(ent *Entity) (string, error) {
+			if err := ent.Replace(c); err != nil {
+				return "", err
+			}
+			return ent.String(), nil
+		})
+	})
+
+	registerConsoleCommand("remove", "<id> <component ID>", func(w *World, args []string) (string, error) {
+		if len(args) != 2 {
+			return "", errUsage
+		}
+		return editEntity(w, args[0], func(ent *Entity) (string, error) {
+			if ent.Remove(args[1]) == 0 {
+				return "", fmt.Errorf("entity %d has no %s component", ent.ID, args[1])
+			}
+			return ent.String(), nil
+		})
+	})
+
+	registerConsoleCommand("tp", "<x> <y>", func(w *World, args []string) (string, error) {
+		if len(args) != 2 {
+			return "", errUsage
+		}
+		x, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return "", errUsage
+		}
+		y, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return "", errUsage
+		}
+		w.SetPlayerPosition(x, y)
+		return fmt.Sprintf("player at (%d, %d)", x, y), nil
+	})
+
+	registerConsoleCommand("time", "[set <time> | add <ticks>]", func(w *World, args []string) (string, error) {
+		switch {
+		case len(args) == 0:
+		case len(args) == 2 && (args[0] == "set" || args[0] == "add"):
+			n, err := strconv.ParseUint(args[1], 10, 64)
+			if err != nil {
+				return "", errUsage
+			}
+			t := Timestamp(n)
+			if args[0] == "add" {
+				var ok bool
+				if t, ok = w.now().Add(Duration(n)); !ok {
+					return "", fmt.Errorf("that is past the end of time")
+				}
+			}
+			if err = w.SetTime(t); err != nil {
+				return "", err
+			}
+		default:
+			return "", errUsage
+		}
+		t := w.Time()
+		return fmt.Sprintf("time %v: %s (tick %d)", t, t.Date(), t.Tick()), nil
+	})
+
+	registerConsoleCommand("schedule", "", func(w *World, args []string) (string, error) {
+		if len(args) != 0 {
+			return "", errUsage
+		}
+		events, err := w.Scheduled(0, 0)
+		if err != nil {
+			return "", err
+		}
+		if len(events) == 0 {
+			return "nothing scheduled", nil
+		}
+		lines := make([]string, len(events))
+		for i, e := range events {
+			lines[i] = e.String()
+		}
+		return strings.Join(lines, "\n"), nil
+	})
+
+	registerConsoleCommand("light", "[<x> <y>]", func(w *World, args []string) (string, error) {
+		x, y := w.PlayerPosition()
+		switch len(args) {
+		case 0:
+		case 2:
+			var err error
+			if x, err = strconv.ParseInt(args[0], 10, 64); err != nil {
+				return "", errUsage
+			}
+			if y, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+				return "", errUsage
+			}
+		default:
+			return "", errUsage
+		}
+		light, open, err := w.LightAt(x, y)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("light at (%d, %d): %d of %d (open to the sky: %v)", x, y, light, LightFull, open), nil
+	})
+
+	registerConsoleCommand("regen", "[<chunk x> <chunk y>]", func(w *World, args []string) (string, error) {
+		coord := ChunkForTile(w.PlayerPosition())
+		switch len(args) {
+		case 0:
+		case 2:
+			x, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return "", errUsage
+			}
+			y, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return "", errUsage
+			}
+			coord = ChunkCoord{x, y}
+		default:
+			return "", errUsage
+		}
+		if err := w.RegenerateChunk(coord); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("regenerated chunk (%d, %d)", coord.X, coord.Y), nil
+	})
+
+	registerConsoleCommand("flush", "", func(w *World, args []string) (string, error) {
+		if len(args) != 0 {
+			return "", errUsage
+		}
+		if err := w.Save(); err != nil {
+			return "", err
+		}
+		return "saved", nil
+	})
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Recipe describes what Craft does with its materials.
+type Recipe struct {
+	// Consumed materials are destroyed when the product is made.
+	Consumed []EntityReference
+
+	// Transformed materials are kept. Transform is called with a copy of
+	// each of them, for example to turn a log into a stump, and the
+	// copies take the materials' place once every one has been
+	// transformed.
+	Transformed []EntityReference
+	Transform   func(material *Entity) error
+
+	// Build is called with the new product before it is added to the
+	// world, to add whatever components make it the thing it is. The
+	// product's ID is not known yet, and it already has its CREATED
+	// record.
+	Build func(product *Entity) error
+}
+
+// Craft makes a new entity from the materials in r. The product gets a
+// CREATED record naming creator, location, the current time and every
+// material, and creator gets a CREATED_BY record for the product. creator
+// and location may be 0.
+//
+// Every material is checked, and Transform and Build are called, before
+// anything changes; if any of them fails, Craft does nothing. Consumed
+// materials must not be referenced by anything else, as for DestroyEntity.
+// Transform and Build are called with the world locked, so they must not
+// call World methods, but they can use Entity methods like Add and Replace.
+func (w *World) Craft(creator, location EntityReference, r Recipe) (product EntityReference, err error) {
+	w.Lock()
+	defer w.Unlock()
+
+	var materials []EntityReference
+	var consumed, transformed []*Entity
+	defer func() {
+		for _, ent := range consumed {
+			if ent.references != 0 {
+				w.releaseEntity(ent)
+			}
+		}
+		for _, ent := range transformed {
+			w.releaseEntity(ent)
+		}
+	}()
+
+	seen := make(map[EntityReference]bool)
+	for _, ids := range [][]EntityReference{r.Consumed, r.Transformed} {
+		for _, id := range ids {
+			if id == creator {
+				return 0, fmt.Errorf("entity %d cannot be both the creator and a material", id)
+			}
+			if seen[id] {
+				return 0, fmt.Errorf("entity %d is used as a material more than once", id)
+			}
+			seen[id] = true
+			materials = append(materials, id)
+		}
+	}
+
+	for _, id := range r.Consumed {
+		ent, err := w.requestEntity(id)
+		if err != nil {
+			return 0, err
+		}
+		consumed = append(consumed, ent)
+	}
+	for _, id := range r.Transformed {
+		ent, err := w.requestEntity(id)
+		if err != nil {
+			return 0, err
+		}
+		transformed = append(transformed, ent)
+	}
+
+	var creatorEnt *Entity
+	if creator != 0 {
+		if creatorEnt, err = w.requestEntity(creator); err != nil {
+			return
+		}
+		defer w.releaseEntity(creatorEnt)
+	}
+
+	if err = w.checkDestroy(consumed); err != nil {
+		return
+	}
+
+	now := w.now()
+
+	draft := &Entity{}
+	err = draft.add(&CreatedComponent{
+		ID:       creator,
+		Location: location,
+		Time:     now,
+		Material: materials,
+	})
+	if err == nil && r.Build != nil {
+		err = r.Build(draft)
+	}
+	if err != nil {
+		return
+	}
+
+	transforms := make([]*Entity, len(transformed))
+	for i, m := range transformed {
+		var b []byte
+		m.RDo(func() {
+			b, err = encodeEntity(m)
+		})
+		if err == nil {
+			transforms[i], err = decodeEntity(b)
+		}
+		if err == nil && r.Transform != nil {
+			err = r.Transform(transforms[i])
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	ent, err := w.newEntity()
+	if err != nil {
+		return
+	}
+	defer w.releaseEntity(ent)
+
+	ent.Do(func() {
+		ent.Components = draft.Components
+	})
+	if creatorEnt != nil {
+		creatorEnt.Do(func() {
+			creatorEnt.Components = append(creatorEnt.Components, &CreatedByComponent{ID: ent.ID})
+		})
+	}
+	for i, m := range transformed {
+		m.Do(func() {
+			m.Components = transforms[i].Components
+		})
+	}
+	err = w.recordEvent(EventCrafted, append([]EntityReference{ent.ID, creator, location}, materials...)...)
+	if err != nil {
+		return
+	}
+	if err = w.destroyEntities(consumed); err != nil {
+		return
+	}
+
+	return ent.ID, nil
+}
+
+// Provenance is the origin of an entity, as recorded by Craft.
+type Provenance struct {
+	ID EntityReference
+
+	// Created is false for entities that were not crafted, such as
+	// those made by world generation.
+	Created  bool
+	Creator  EntityReference
+	Location EntityReference
+	Time     Timestamp
+
+	// Destroyed is when the entity was destroyed, or 0.
+	Destroyed Timestamp
+
+	Materials []*Provenance
+}
+
+// Provenance returns the full chain of origin of id, following the
+// materials of every crafted entity back to ones that were not crafted.
+// Destroyed materials are read from their tombstones.
+func (w *World) Provenance(id EntityReference) (p *Provenance, err error) {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.provenance(id, make(map[EntityReference]bool))
+}
+
+func (w *World) provenance(id EntityReference, path map[EntityReference]bool) (p *Provenance, err error) {
+	if path[id] {
+		return nil, fmt.Errorf("entity %d is made from itself", id)
+	}
+	path[id] = true
+	defer delete(path, id)
+
+	p = &Provenance{ID: id}
+	var materials []EntityReference
+	err = w.viewEntity(id, func(ent *Entity, destroyed Timestamp) {
+		p.Destroyed = destroyed
+		if c, ok := ent.get("CREATED").(*CreatedComponent); ok {
+			p.Created = true
+			p.Creator, p.Location, p.Time = c.ID, c.Location, c.Time
+			materials = append(materials, c.Material...)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range materials {
+		var mp *Provenance
+		if mp, err = w.provenance(m, path); err != nil {
+			return nil, err
+		}
+		p.Materials = append(p.Materials, mp)
+	}
+	return
+}
+
+// String returns the provenance as an indented tree, one entity per line.
+func (p *Provenance) String() string {
+	var buf []string
+	p.format(&buf, 0)
+	return strings.Join(buf, "\n")
+}
+
+func (p *Provenance) format(buf *[]string, depth int) {
+	line := strings.Repeat("\t", depth) + fmt.Sprintf("entity %d", p.ID)
+	if p.Created {
+		line += fmt.Sprintf(" created at %v", p.Time)
+		if p.Creator != 0 {
+			line += fmt.Sprintf(" by %d", p.Creator)
+		}
+		if p.Location != 0 {
+			line += fmt.Sprintf(" in %d", p.Location)
+		}
+	}
+	if p.Destroyed != 0 {
+		line += fmt.Sprintf(", destroyed at %v", p.Destroyed)
+	}
+	*buf = append(*buf, line)
+
+	for _, m := range p.Materials {
+		m.format(buf, depth+1)
+	}
+}
@@ -9,13 +9,18 @@ type CreatedByComponent struct {
 }
 
 func init() {
-	registerComponentType(&CreatedByComponent{})
+	registerComponentType(ComponentType{ID: "CREATED_BY", Version: 1, Proto: &CreatedByComponent{}})
 }
 
 func (c *CreatedByComponent) String() string {
 	return fmt.Sprintf("CREATED_BY id[entity]=%v", c.ID)
 }
 
+func (c *CreatedByComponent) parseText(f *textFields) error {
+	c.ID = f.Entity("id")
+	return f.Done()
+}
+
 type CreatedComponent struct {
 	ID       EntityReference
 	Location EntityReference
@@ -24,9 +29,37 @@ type CreatedComponent struct {
 }
 
 func init() {
-	registerComponentType(&CreatedComponent{})
+	registerComponentType(ComponentType{ID: "CREATED", Version: 1, Single: true, Proto: &CreatedComponent{}})
 }
 
 func (c *CreatedComponent) String() string {
 	return fmt.Sprintf("CREATED id[entity]=%v location[entity]=%v time[time]=%v material[entities]=%v", c.ID, c.Location, c.Time, c.Material)
 }
+
+func (c *CreatedComponent) parseText(f *textFields) error {
+	c.ID = f.Entity("id")
+	c.Location = f.Entity("location")
+	c.Time = f.Time("time")
+	c.Material = f.Entities("material")
+	return f.Done()
+}
+
+// Created returns a copy of e's CreatedComponent.
+func (e *Entity) Created() (created CreatedComponent, ok bool) {
+	e.RDo(func() {
+		if c, isCreated := e.get("CREATED").(*CreatedComponent); isCreated {
+			created, ok = *c, true
+			created.Material = append([]EntityReference(nil), c.Material...)
+		}
+	})
+	return
+}
+
+// CreatedBy returns the entities e has created, according to its CREATED_BY
+// components.
+func (e *Entity) CreatedBy() (ids []EntityReference) {
+	for _, c := range e.GetAll("CREATED_BY") {
+		ids = append(ids, c.(*CreatedByComponent).ID)
+	}
+	return
+}
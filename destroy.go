@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrEntityDestroyed is returned by RequestEntity for entities that have
+// been destroyed.
+var ErrEntityDestroyed = errors.New("entity has been destroyed")
+
+// A destroyed entity's last record is kept in the tombstone collection under
+// its ID, so references to it from history (former owners, the materials
+// something was made from) can still be followed. IDs are never reused.
+type tombstone struct {
+	Destroyed Timestamp
+	Entity    []byte // as written by encodeEntity
+}
+
+// DestroyEntity removes ent from the world. The caller must hold the only
+// reference to ent, which is released. It fails without changing anything if
+// an entity in the cache still uses ent as its container. Stored entities
+// inside ent are moved to the top level when they are next loaded.
+//
+// Ownership that is still open ends at the current time, both ent's own and
+// that of every entity it owns, whether cached or stored, and each is
+// recorded as an ownership event. It fails without changing anything if any
+// of that ownership started after the current time.
+//
+// References that describe the past, like CREATED and OWNER_OF, are left
+// alone and can be looked up with Tombstone.
+func (w *World) DestroyEntity(ent *Entity) (err error) {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.destroyEntities([]*Entity{ent})
+}
+
+// destroyEntities destroys every entity in ents, after checking that all of
+// them can be destroyed. The caller must hold w's lock.
+func (w *World) destroyEntities(ents []*Entity) (err error) {
+	if err = w.checkDestroy(ents); err != nil {
+		return
+	}
+	for _, ent := range ents {
+		if err = w.destroyEntity(ent); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// checkDestroy returns an error if any of ents cannot be destroyed.
+// References between the entities in ents do not count. The caller must hold
+// w's lock.
+func (w *World) checkDestroy(ents []*Entity) error {
+	doomed := make(map[EntityReference]bool, len(ents))
+	for _, ent := range ents {
+		if ent == nil {
+			panic("destroy of nil entity")
+		}
+		if w.entities[ent.ID] != ent {
+			panic(fmt.Sprintf("Entity %d destroyed, but a different object was in the cache:\n\n%v\n\n%v", ent.ID, ent, w.entities[ent.ID]))
+		}
+		if ent.references != 1 {
+			return fmt.Errorf("entity %d is still referenced %d other times", ent.ID, ent.references-1)
+		}
+		doomed[ent.ID] = true
+	}
+
+	for _, other := range w.entities {
+		if doomed[other.ID] {
+			continue
+		}
+		var container EntityReference
+		other.RDo(func() {
+			if l := other.location(); l != nil && doomed[l.ID] {
+				container = l.ID
+			}
+		})
+		if container != 0 {
+			return fmt.Errorf("entity %d is still in use as the container of entity %d", container, other.ID)
+		}
+	}
+
+	// ownership that destroying ents would end must not have started in
+	// the future, as it can after the clock is moved back.
+	now := w.now()
+	for _, ent := range ents {
+		var owned []EntityReference
+		var late *OwnerComponent
+		ent.RDo(func() {
+			for _, c := range ent.Components {
+				switch c := c.(type) {
+				case *OwnerComponent:
+					if c.End == 0 && c.Start > now {
+						late = c
+					}
+				case *OwnerOfComponent:
+					if !doomed[c.ID] {
+						owned = append(owned, c.ID)
+					}
+				}
+			}
+		})
+		if late != nil {
+			return fmt.Errorf("entity %d has been owned by entity %d since %v, after %v", ent.ID, late.ID, late.Start, now)
+		}
+		for _, id := range owned {
+			err := w.viewEntity(id, func(item *Entity, destroyed Timestamp) {
+				if destroyed != 0 {
+					return
+				}
+				for _, c := range item.Components {
+					if o, ok := c.(*OwnerComponent); ok && o.End == 0 && o.ID == ent.ID && o.Start > now {
+						late = o
+					}
+				}
+			})
+			if err != nil {
+				return err
+			}
+			if late != nil {
+				return fmt.Errorf("entity %d has been owned by entity %d since %v, after %v", id, ent.ID, late.Start, now)
+			}
+		}
+	}
+	return nil
+}
+
+// destroyEntity destroys ent, which checkDestroy must have accepted.
+func (w *World) destroyEntity(ent *Entity) (err error) {
+	now := w.now()
+
+	// the tombstone gets a copy, so ent is unchanged if it cannot be
+	// written.
+	var owned []EntityReference
+	var owner EntityReference
+	var record []byte
+	ent.RDo(func() {
+		last := &Entity{ID: ent.ID, Components: make([]Component, len(ent.Components))}
+		for i, c := range ent.Components {
+			last.Components[i] = c
+			switch c := c.(type) {
+			case *OwnerComponent:
+				if c.End == 0 {
+					ended := *c
+					ended.End = now
+					last.Components[i] = &ended
+					owner = c.ID
+				}
+			case *OwnerOfComponent:
+				owned = append(owned, c.ID)
+			}
+		}
+		record, err = encodeEntity(last)
+	})
+	if err != nil {
+		return
+	}
+	b, err := objectToBytes(&tombstone{Destroyed: now, Entity: record})
+	if err != nil {
+		return
+	}
+
+	for _, id := range owned {
+		if err = w.disownEntity(id, ent.ID, now); err != nil {
+			return
+		}
+	}
+	if owner != 0 {
+		if err = w.recordEventAt(now, EventOwnership, ent.ID, owner, 0); err != nil {
+			return
+		}
+	}
+
+	if err = w.tombstone.Set(ent.ID.bytes(), b); err != nil {
+		return
+	}
+	if err = w.entity.Delete(ent.ID.bytes()); err != nil {
+		return
+	}
+	if err = updateLocationIndex(w.location, ent.ID, nil); err != nil {
+		return
+	}
+	if err = w.recordEvent(EventDestroyed, ent.ID); err != nil {
+		return
+	}
+
+	ent.references = 0
+	delete(w.entities, ent.ID)
+	return
+}
+
+// disownEntity ends id's ownership by owner at the given time, if owner
+// still owns it. Entities that have already been destroyed are skipped. The
+// caller must hold w's lock.
+func (w *World) disownEntity(id, owner EntityReference, at Timestamp) error {
+	item, err := w.requestEntity(id)
+	if err == ErrEntityDestroyed {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	current, ok := item.currentOwner()
+	w.releaseEntity(item)
+
+	if !ok || current.ID != owner {
+		return nil
+	}
+	return w.transferOwnership(id, 0, at)
+}
+
+// clearDestroyedContainer moves an entity whose container was destroyed to
+// the top level. ent must not be shared yet.
+func (w *World) clearDestroyedContainer(ent *Entity) error {
+	l := ent.location()
+	if l == nil || l.ID == 0 {
+		return nil
+	}
+	v, err := w.tombstone.Get(l.ID.bytes())
+	if err != nil || v == nil {
+		return err
+	}
+	l.ID = 0
+	ent.dirty = true
+	return nil
+}
+
+// Tombstone returns the last record of a destroyed entity and when it was
+// destroyed. ent is nil if id has not been destroyed. ent is not part of the
+// world and must not be released.
+func (w *World) Tombstone(id EntityReference) (ent *Entity, destroyed Timestamp, err error) {
+	v, err := w.tombstone.Get(id.bytes())
+	if err != nil || v == nil {
+		return
+	}
+
+	var t tombstone
+	if err = bytesToObject(&t, v); err != nil {
+		return
+	}
+	ent, err = decodeEntity(t.Entity)
+	destroyed = t.Destroyed
+	return
+}
+
+// viewEntity calls f with the entity id, whether it is cached, stored or
+// destroyed, without adding it to the cache. f must not change the entity.
+// The caller must hold w's lock.
+func (w *World) viewEntity(id EntityReference, f func(ent *Entity, destroyed Timestamp)) error {
+	if ent := w.entities[id]; ent != nil {
+		ent.RDo(func() {
+			f(ent, 0)
+		})
+		return nil
+	}
+
+	v, err := w.entity.Get(id.bytes())
+	if err != nil {
+		return err
+	}
+	if v != nil {
+		ent, err := decodeEntity(v)
+		if err != nil {
+			return err
+		}
+		f(ent, 0)
+		return nil
+	}
+
+	ent, destroyed, err := w.Tombstone(id)
+	if err != nil {
+		return err
+	}
+	if ent == nil {
+		return fmt.Errorf("entity %d does not exist", id)
+	}
+	f(ent, destroyed)
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"github.com/BenLubar/untitled-game/language"
+)
+
+// Duration is a length of game time, in ticks.
+type Duration uint64
+
+// maxDuration is the longest Duration. It is longer than all of time, so
+// adding it to any Timestamp fails.
+const maxDuration = ^Duration(0)
+
+// Days returns a Duration of n days, or maxDuration if that is too long to
+// hold.
+func Days(n uint64) Duration {
+	return scaleDuration(n, Duration(ts_ticks_per_day))
+}
+
+// Years returns a Duration of n years, or maxDuration if that is too long to
+// hold.
+func Years(n uint64) Duration {
+	return scaleDuration(n, Duration(ts_ticks_per_year))
+}
+
+func scaleDuration(n uint64, unit Duration) Duration {
+	if Duration(n) > maxDuration/unit {
+		return maxDuration
+	}
+	return Duration(n) * unit
+}
+
+// Years returns the number of whole years in d.
+func (d Duration) Years() uint64 {
+	return uint64(d / Duration(ts_ticks_per_year))
+}
+
+// Days returns the number of whole days in d after taking out the years.
+func (d Duration) Days() uint64 {
+	return uint64(d % Duration(ts_ticks_per_year) / Duration(ts_ticks_per_day))
+}
+
+// String returns d as an age, such as "3 years, 12 days", in the game's
+// language. Parts of a day are left out.
+func (d Duration) String() string {
+	return language.Age(int(d.Years()), int(d.Days()))
+}
+
+// Add returns t+d. It returns false if t is the nil time or t+d is past
+// ts_max.
+func (t Timestamp) Add(d Duration) (Timestamp, bool) {
+	if t == 0 || Duration(ts_max-t) < d {
+		return 0, false
+	}
+	return t + Timestamp(d), true
+}
+
+// SubDuration returns t-d. It returns false if t is the nil time or t-d is
+// before ts_min.
+func (t Timestamp) SubDuration(d Duration) (Timestamp, bool) {
+	if t == 0 || Duration(t-ts_min) < d {
+		return 0, false
+	}
+	return t - Timestamp(d), true
+}
+
+// Sub returns the time from u to t. It returns false if either is the nil
+// time or u is after t.
+func (t Timestamp) Sub(u Timestamp) (Duration, bool) {
+	if t == 0 || u == 0 || u > t {
+		return 0, false
+	}
+	return Duration(t - u), true
+}
+
+// Before returns true if t is before u. The nil time is neither before nor
+// after anything.
+func (t Timestamp) Before(u Timestamp) bool {
+	return t != 0 && u != 0 && t < u
+}
+
+// After returns true if t is after u. The nil time is neither before nor
+// after anything.
+func (t Timestamp) After(u Timestamp) bool {
+	return t != 0 && u != 0 && t > u
+}
+
+// Between returns true if t is at or after start and before end, the way
+// OwnerComponent spans are stored: a nil start has no beginning and a nil
+// end has not ended yet. The nil time is not between anything.
+func (t Timestamp) Between(start, end Timestamp) bool {
+	return t != 0 && (start == 0 || t >= start) && (end == 0 || t < end)
+}
+
+// Age returns how long it has been from t until now, and false if either is
+// the nil time or t is after now.
+func (t Timestamp) Age(now Timestamp) (Duration, bool) {
+	return now.Sub(t)
+}
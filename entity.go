@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"encoding/binary"
+	"fmt"
+	"io"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -18,6 +22,7 @@ type Entity struct {
 	ID         EntityReference
 	Components []Component
 	references uint
+	dirty      bool // changed since it was last written to the store
 	mtx        sync.RWMutex
 }
 
@@ -36,10 +41,76 @@ func (e *Entity) String() string {
 	return string(buf)
 }
 
+// ParseEntities reads entities in the format written by Entity.String, one
+// after another. Blank lines between entities and lines starting with # are
+// ignored, so files of entities can be written and commented by hand.
+func ParseEntities(r io.Reader) (entities []*Entity, err error) {
+	return parseEntityBlocks(r, func(f *textFields) (*Entity, error) {
+		if f.Tag != "ENTITY" {
+			return nil, fmt.Errorf("expected ENTITY, not %s", f.Tag)
+		}
+		ent := &Entity{ID: f.Entity("id")}
+		return ent, f.Done()
+	})
+}
+
+// ParseEntity reads a single entity in the format written by Entity.String.
+func ParseEntity(text string) (*Entity, error) {
+	ents, err := ParseEntities(strings.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+	if len(ents) != 1 {
+		return nil, fmt.Errorf("expected 1 entity, not %d", len(ents))
+	}
+	return ents[0], nil
+}
+
+// parseEntityBlocks reads entities written as a header line, parsed by
+// header, followed by one tab-indented line per component.
+func parseEntityBlocks(r io.Reader, header func(f *textFields) (*Entity, error)) (entities []*Entity, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	var ent *Entity
+	for line := 1; s.Scan(); line++ {
+		text := s.Text()
+		switch {
+		case strings.TrimSpace(text) == "", strings.HasPrefix(strings.TrimSpace(text), "#"):
+			continue
+
+		case strings.HasPrefix(text, "\t"):
+			if ent == nil {
+				return nil, fmt.Errorf("line %d: component outside of an entity", line)
+			}
+			c, err := ParseComponent(text)
+			if err == nil {
+				err = ent.add(c)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+
+		default:
+			f, err := parseTextFields(text)
+			if err == nil {
+				ent, err = header(f)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			entities = append(entities, ent)
+		}
+	}
+	return entities, s.Err()
+}
+
+// Do calls f with e locked for writing. The entity is assumed to have been
+// changed, so it is written to the store again when it is released.
 func (e *Entity) Do(f func()) {
 	e.mtx.Lock()
 	defer e.mtx.Unlock()
 
+	e.dirty = true
 	f()
 }
 
@@ -49,3 +120,224 @@ func (e *Entity) RDo(f func()) {
 
 	f()
 }
+
+// The methods below lock e themselves, so they must not be called from f in
+// Do or RDo. Components are identified by their ComponentType ID.
+
+// Has returns true if e has at least one component with the given ID.
+func (e *Entity) Has(id string) (has bool) {
+	e.RDo(func() {
+		has = e.get(id) != nil
+	})
+	return
+}
+
+// Get returns e's first component with the given ID, or nil. The component
+// is shared with e; change it inside Do, or change a copy and Replace it.
+func (e *Entity) Get(id string) (c Component) {
+	e.RDo(func() {
+		c = e.get(id)
+	})
+	return
+}
+
+func (e *Entity) get(id string) Component {
+	for _, c := range e.Components {
+		if componentID(c) == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// GetAll returns every component on e with the given ID, in order.
+func (e *Entity) GetAll(id string) (all []Component) {
+	e.RDo(func() {
+		for _, c := range e.Components {
+			if componentID(c) == id {
+				all = append(all, c)
+			}
+		}
+	})
+	return
+}
+
+// Add adds c to e. It fails if c's type is not registered, or if it is a
+// Single type and e already has one.
+func (e *Entity) Add(c Component) (err error) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	return e.add(c)
+}
+
+func (e *Entity) add(c Component) error {
+	id := componentID(c)
+	if id == "" {
+		return fmt.Errorf("unregistered component type %T", c)
+	}
+	if t, ok := componentTypesByID[id]; ok && t.Single && e.get(id) != nil {
+		return fmt.Errorf("entity %d already has a %s component", e.ID, id)
+	}
+	e.Components = append(e.Components, c)
+	e.dirty = true
+	return nil
+}
+
+// Replace removes every component on e with c's ID and puts c in place of
+// the first one, or adds c if there were none.
+func (e *Entity) Replace(c Component) error {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	id := componentID(c)
+	if id == "" {
+		return fmt.Errorf("unregistered component type %T", c)
+	}
+
+	replaced := false
+	kept := e.Components[:0]
+	for _, old := range e.Components {
+		if componentID(old) != id {
+			kept = append(kept, old)
+		} else if !replaced {
+			kept = append(kept, c)
+			replaced = true
+		}
+	}
+	e.Components = kept
+	if !replaced {
+		e.Components = append(e.Components, c)
+	}
+	e.dirty = true
+	return nil
+}
+
+// Remove removes every component on e with the given ID and returns how
+// many there were.
+func (e *Entity) Remove(id string) (removed int) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	kept := e.Components[:0]
+	for _, c := range e.Components {
+		if componentID(c) == id {
+			removed++
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	for i := len(kept); i < len(e.Components); i++ {
+		e.Components[i] = nil
+	}
+	e.Components = kept
+	if removed != 0 {
+		e.dirty = true
+	}
+	return
+}
+
+// RemoveComponent removes c itself from e, leaving any other components with
+// the same ID. It returns false if c was not on e.
+func (e *Entity) RemoveComponent(c Component) bool {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	for i, other := range e.Components {
+		if other == c {
+			e.Components = append(e.Components[:i], e.Components[i+1:]...)
+			e.dirty = true
+			return true
+		}
+	}
+	return false
+}
+
+type savedEntity struct {
+	ID         EntityReference
+	Components []savedComponent
+}
+
+// encodeEntity returns the stored form of ent. The caller must hold ent's
+// lock or be the only user of ent.
+func encodeEntity(ent *Entity) ([]byte, error) {
+	saved := savedEntity{
+		ID:         ent.ID,
+		Components: make([]savedComponent, len(ent.Components)),
+	}
+	for i, c := range ent.Components {
+		var err error
+		saved.Components[i], err = encodeComponent(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objectToBytes(&saved)
+}
+
+func decodeEntity(b []byte) (*Entity, error) {
+	var saved savedEntity
+	err := bytesToObject(&saved, b)
+	if err != nil {
+		return nil, err
+	}
+
+	ent := &Entity{
+		ID:         saved.ID,
+		Components: make([]Component, len(saved.Components)),
+	}
+	for i, c := range saved.Components {
+		ent.Components[i], err = decodeComponent(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ent, nil
+}
+
+func init() {
+	registerMigration(1, "stable component IDs", func(w *World) (err error) {
+		var keys [][]byte
+		err = w.entity.Visit(nil, func(k, v []byte) bool {
+			keys = append(keys, k)
+			return true
+		})
+		if err != nil {
+			return
+		}
+
+		for _, k := range keys {
+			var v []byte
+			v, err = w.entity.Get(k)
+			if err != nil {
+				return
+			}
+
+			if _, err := decodeEntity(v); err == nil {
+				// converted by an earlier, interrupted run.
+				continue
+			}
+
+			// version 1 saves were the Entity struct itself, with
+			// components stored under their Go type names.
+			var legacy struct {
+				ID         EntityReference
+				Components []Component
+			}
+			err = bytesToObject(&legacy, v)
+			if err != nil {
+				return
+			}
+
+			v, err = encodeEntity(&Entity{ID: legacy.ID, Components: legacy.Components})
+			if err != nil {
+				return
+			}
+			err = w.entity.Set(k, v)
+			if err != nil {
+				return
+			}
+		}
+		return
+	})
+}
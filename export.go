@@ -0,0 +1,706 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"github.com/BenLubar/untitled-game/simplex"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The export format is a directory containing:
+//
+//	global.txt      the global collection, one key per line
+//	chunks/X_Y.txt  one file per stored chunk
+//	entities.txt    every stored entity, as written by Entity.String
+//	tombstones.txt  the last record of every destroyed entity, as in
+//	                entities.txt but starting with
+//	                TOMBSTONE id[entity]=N destroyed[time]=T
+//	history.txt     every HistoryEvent, oldest first, one per line
+//	schedule.txt    every queued ScheduledEvent, soonest first, one per line
+//
+// Apart from the rows of tiles in chunk files, every line uses the typed
+// key/value format of Component.String. global.txt may contain:
+//
+//	VERSION version[int]=2                     save version of the exported world
+//	SEED text[text]="..." buf[bytes]=... ptr[int]=0
+//	SIMPLEX perm[ints]=(151,160,...)           256 values; the simplex table repeats them
+//	TIME time[time]=0
+//	ENTID id[entity]=17                        the last entity ID handed out
+//	MIGRATION from[int]=1 to[int]=2 name[text]="..." when[text]="2006-01-02T15:04:05Z"
+//	PARENT name[text]="..."                    the save this one was forked from
+//	FORKED time[time]=0                        when it was forked
+//	EVENTSEQ seq[int]=0                        the last history event number
+//	SCHEDSEQ seq[int]=0                        the last scheduled event number
+//	META created[text]="2006-01-02T15:04:05Z" played[int]=3600 player[ints]=(0,0)
+//	                                           SaveMeta, minus what other keys hold
+//	RAW key[text]="..." value[bytes]=...       any other key, exactly as stored
+//
+// A chunk file is a CHUNK coord[ints]=(X,Y) line followed by ChunkSize rows
+// of ChunkSize tiles, highest Y first so the file looks like the world:
+//
+//	. air   # rock   : sand   % dirt   " grass   ~ water
+//
+// Import always writes the current save encoding, so a world can be exported
+// by one version of the game and imported by a later one. Indexes are not
+// exported; import rebuilds them.
+
+var tileRunes = [...]rune{
+	TileAir:   '.',
+	TileRock:  '#',
+	TileSand:  ':',
+	TileDirt:  '%',
+	TileGrass: '"',
+	TileWater: '~',
+}
+
+func init() {
+	registerCommand("export", "<save> <dir>", func(args []string) error {
+		if len(args) != 2 {
+			return errUsage
+		}
+		w, err := openSave(args[0])
+		if err != nil {
+			return err
+		}
+		defer w.store.Close()
+		return exportWorld(w, args[1])
+	})
+	registerCommand("import", "<dir> <save>", func(args []string) error {
+		if len(args) != 2 {
+			return errUsage
+		}
+		return importWorld(args[0], savePath(args[1]))
+	})
+}
+
+// exportWorld writes the save in w's store to dir. Anything in w's caches
+// must already be in the store, as it is after openSave.
+func exportWorld(w *World, dir string) (err error) {
+	err = os.Mkdir(dir, 0777)
+	if err != nil {
+		return
+	}
+	err = os.Mkdir(filepath.Join(dir, "chunks"), 0777)
+	if err != nil {
+		return
+	}
+
+	err = writeExportFile(filepath.Join(dir, "global.txt"), func(out *bufio.Writer) error {
+		return exportGlobal(w, out)
+	})
+	if err != nil {
+		return
+	}
+
+	var visitErr error
+	err = w.chunk.Visit(nil, func(k, v []byte) bool {
+		var c *Chunk
+		if visitErr = bytesToObject(&c, v); visitErr != nil {
+			return false
+		}
+		name := filepath.Join(dir, "chunks", fmt.Sprintf("%d_%d.txt", c.X, c.Y))
+		visitErr = writeExportFile(name, func(out *bufio.Writer) error {
+			fmt.Fprintf(out, "CHUNK coord[ints]=(%d,%d)\n", c.X, c.Y)
+			for y := ChunkSize - 1; y >= 0; y-- {
+				for x := 0; x < ChunkSize; x++ {
+					out.WriteRune(tileRunes[c.Tiles[x][y].Type])
+				}
+				out.WriteByte('\n')
+			}
+			return nil
+		})
+		return visitErr == nil
+	})
+	if err == nil {
+		err = visitErr
+	}
+	if err != nil {
+		return
+	}
+
+	err = writeExportFile(filepath.Join(dir, "entities.txt"), func(out *bufio.Writer) error {
+		err := w.entity.Visit(nil, func(k, v []byte) bool {
+			var ent *Entity
+			if ent, visitErr = decodeEntity(v); visitErr != nil {
+				return false
+			}
+			out.WriteString(ent.String())
+			out.WriteString("\n\n")
+			return true
+		})
+		if err == nil {
+			err = visitErr
+		}
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	err = writeExportFile(filepath.Join(dir, "tombstones.txt"), func(out *bufio.Writer) error {
+		err := w.tombstone.Visit(nil, func(k, v []byte) bool {
+			var t tombstone
+			var ent *Entity
+			if visitErr = bytesToObject(&t, v); visitErr != nil {
+				return false
+			}
+			if ent, visitErr = decodeEntity(t.Entity); visitErr != nil {
+				return false
+			}
+			s := ent.String()
+			fmt.Fprintf(out, "TOMBSTONE id[entity]=%v destroyed[time]=%v", ent.ID, t.Destroyed)
+			out.WriteString(s[strings.IndexByte(s+"\n", '\n'):])
+			out.WriteString("\n\n")
+			return true
+		})
+		if err == nil {
+			err = visitErr
+		}
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	events, err := w.History(0, 0)
+	if err != nil {
+		return
+	}
+	err = writeExportFile(filepath.Join(dir, "history.txt"), func(out *bufio.Writer) error {
+		for _, e := range events {
+			out.WriteString(e.String())
+			out.WriteByte('\n')
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	scheduled, err := w.Scheduled(0, 0)
+	if err != nil {
+		return
+	}
+	err = writeExportFile(filepath.Join(dir, "schedule.txt"), func(out *bufio.Writer) error {
+		for _, e := range scheduled {
+			out.WriteString(e.String())
+			out.WriteByte('\n')
+		}
+		return nil
+	})
+	return
+}
+
+func writeExportFile(name string, write func(*bufio.Writer) error) (err error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err_ := f.Close()
+		if err == nil {
+			err = err_
+		}
+	}()
+
+	out := bufio.NewWriter(f)
+	err = write(out)
+	if err != nil {
+		return
+	}
+	err = out.Flush()
+	return
+}
+
+func exportGlobal(w *World, out *bufio.Writer) (err error) {
+	var visitErr error
+	err = w.global.Visit(nil, func(k, v []byte) bool {
+		switch string(k) {
+		case string(kVersion):
+			fmt.Fprintf(out, "VERSION version[int]=%d\n", binary.BigEndian.Uint64(v))
+
+		case string(kSeed):
+			var seed Seed
+			if visitErr = gob.NewDecoder(bytes.NewReader(v)).Decode(&seed); visitErr != nil {
+				return false
+			}
+			fmt.Fprintf(out, "SEED text[text]=%q buf[bytes]=%x ptr[int]=%d\n", seed.Text, seed.Buf[:], seed.Ptr)
+
+		case string(kSimplex):
+			var s *simplex.Simplex
+			if visitErr = gob.NewDecoder(bytes.NewReader(v)).Decode(&s); visitErr != nil {
+				return false
+			}
+			out.WriteString("SIMPLEX perm[ints]=(")
+			for i, n := range s[:len(s)/2] {
+				if i != 0 {
+					out.WriteByte(',')
+				}
+				fmt.Fprint(out, n)
+			}
+			out.WriteString(")\n")
+
+		case string(kTime):
+			fmt.Fprintf(out, "TIME time[time]=%d\n", binary.BigEndian.Uint64(v))
+
+		case string(kNextEntityID):
+			fmt.Fprintf(out, "ENTID id[entity]=%d\n", binary.BigEndian.Uint64(v))
+
+		case string(kMigrationLog):
+			var entries []MigrationLogEntry
+			if visitErr = gob.NewDecoder(bytes.NewReader(v)).Decode(&entries); visitErr != nil {
+				return false
+			}
+			for _, e := range entries {
+				fmt.Fprintf(out, "MIGRATION from[int]=%d to[int]=%d name[text]=%q when[text]=%q\n", e.From, e.To, e.Name, e.When.Format(time.RFC3339Nano))
+			}
+
+		case string(kMeta):
+			var meta SaveMeta
+			if visitErr = gob.NewDecoder(bytes.NewReader(v)).Decode(&meta); visitErr != nil {
+				return false
+			}
+			fmt.Fprintf(out, "META created[text]=%q played[int]=%d player[ints]=(%d,%d)\n", meta.Created.Format(time.RFC3339Nano), int64(meta.PlayTime/time.Second), meta.PlayerX, meta.PlayerY)
+
+		case string(kForkParent):
+			fmt.Fprintf(out, "PARENT name[text]=%q\n", v)
+
+		case string(kForkTime):
+			fmt.Fprintf(out, "FORKED time[time]=%d\n", binary.BigEndian.Uint64(v))
+
+		case string(kHistorySeq):
+			fmt.Fprintf(out, "EVENTSEQ seq[int]=%d\n", binary.BigEndian.Uint64(v))
+
+		case string(kScheduleSeq):
+			fmt.Fprintf(out, "SCHEDSEQ seq[int]=%d\n", binary.BigEndian.Uint64(v))
+
+		default:
+			fmt.Fprintf(out, "RAW key[text]=%q value[bytes]=%x\n", k, v)
+		}
+		return true
+	})
+	if err == nil {
+		err = visitErr
+	}
+	return
+}
+
+func importWorld(dir, name string) (err error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0666)
+	if err != nil {
+		return
+	}
+	store, err := NewGkvliteBackend(f)
+	if err != nil {
+		f.Close()
+		os.Remove(name)
+		return
+	}
+	defer func() {
+		err_ := store.Close()
+		if err == nil {
+			err = err_
+		}
+		if err != nil {
+			os.Remove(name)
+		}
+	}()
+
+	err = importGlobal(filepath.Join(dir, "global.txt"), store.Collection("global"))
+	if err != nil {
+		return
+	}
+
+	err = importChunks(filepath.Join(dir, "chunks"), store.Collection("chunk"))
+	if err != nil {
+		return
+	}
+
+	err = importEntities(filepath.Join(dir, "entities.txt"), store.Collection("entity"))
+	if err != nil {
+		return
+	}
+
+	err = importTombstones(filepath.Join(dir, "tombstones.txt"), store.Collection("tombstone"))
+	if err != nil {
+		return
+	}
+
+	err = importHistory(filepath.Join(dir, "history.txt"), store.Collection("history"))
+	if err != nil {
+		return
+	}
+
+	err = importSchedule(filepath.Join(dir, "schedule.txt"), store.Collection("schedule"))
+	if err != nil {
+		return
+	}
+
+	err = rebuildLocationIndex(store.Collection("entity"), store.Collection("location"))
+	if err != nil {
+		return
+	}
+
+	err = store.Flush()
+	return
+}
+
+func importGlobal(name string, global Collection) (err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	set := func(k []byte, v interface{}) error {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+			return err
+		}
+		return global.Set(k, buf.Bytes())
+	}
+	setUint64 := func(k []byte, n uint64) error {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, n)
+		return global.Set(k, b)
+	}
+
+	var migrationLog []MigrationLogEntry
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+
+		var fields *textFields
+		fields, err = parseTextFields(s.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", name, line, err)
+		}
+
+		switch fields.Tag {
+		case "VERSION":
+			version := fields.Uint("version")
+			if err = fields.Done(); err == nil && version > CurrentSaveVersion {
+				err = fmt.Errorf("save version %d is newer than this game supports (%d)", version, CurrentSaveVersion)
+			}
+			if err == nil {
+				// the data below is written in the current encoding.
+				err = setUint64(kVersion, CurrentSaveVersion)
+			}
+
+		case "SEED":
+			var seed Seed
+			seed.Text = fields.Text("text")
+			buf := fields.Bytes("buf")
+			seed.Ptr = uintptr(fields.Uint("ptr"))
+			if err = fields.Done(); err == nil && len(buf) != len(seed.Buf) {
+				err = fmt.Errorf("SEED: buf must be %d bytes, not %d", len(seed.Buf), len(buf))
+			}
+			if err == nil {
+				copy(seed.Buf[:], buf)
+				err = set(kSeed, &seed)
+			}
+
+		case "SIMPLEX":
+			s := new(simplex.Simplex)
+			perm := fields.Ints("perm", len(s)/2, 0, int64(len(s)/2-1))
+			if err = fields.Done(); err == nil {
+				for i, n := range perm {
+					s[i] = int(n)
+					s[i+len(s)/2] = int(n)
+				}
+				err = set(kSimplex, &s)
+			}
+
+		case "TIME":
+			t := fields.Time("time")
+			if err = fields.Done(); err == nil {
+				err = setUint64(kTime, uint64(t))
+			}
+
+		case "ENTID":
+			id := fields.Entity("id")
+			if err = fields.Done(); err == nil {
+				err = setUint64(kNextEntityID, uint64(id))
+			}
+
+		case "MIGRATION":
+			var e MigrationLogEntry
+			e.From = fields.Uint("from")
+			e.To = fields.Uint("to")
+			e.Name = fields.Text("name")
+			when := fields.Text("when")
+			if err = fields.Done(); err == nil {
+				e.When, err = time.Parse(time.RFC3339Nano, when)
+			}
+			migrationLog = append(migrationLog, e)
+
+		case "META":
+			var meta SaveMeta
+			created := fields.Text("created")
+			meta.PlayTime = time.Duration(fields.Int("played", 0, math.MaxInt64/int64(time.Second))) * time.Second
+			player := fields.Ints("player", 2, math.MinInt64, math.MaxInt64)
+			meta.PlayerX, meta.PlayerY = player[0], player[1]
+			if err = fields.Done(); err == nil {
+				meta.Created, err = time.Parse(time.RFC3339Nano, created)
+			}
+			if err == nil {
+				err = set(kMeta, &meta)
+			}
+
+		case "PARENT":
+			parent := fields.Text("name")
+			if err = fields.Done(); err == nil {
+				err = global.Set(kForkParent, []byte(parent))
+			}
+
+		case "FORKED":
+			t := fields.Time("time")
+			if err = fields.Done(); err == nil {
+				err = setUint64(kForkTime, uint64(t))
+			}
+
+		case "EVENTSEQ":
+			seq := fields.Uint("seq")
+			if err = fields.Done(); err == nil {
+				err = setUint64(kHistorySeq, seq)
+			}
+
+		case "SCHEDSEQ":
+			seq := fields.Uint("seq")
+			if err = fields.Done(); err == nil {
+				err = setUint64(kScheduleSeq, seq)
+			}
+
+		case "RAW":
+			k := fields.Text("key")
+			v := fields.Bytes("value")
+			if err = fields.Done(); err == nil {
+				err = global.Set([]byte(k), v)
+			}
+
+		default:
+			err = fmt.Errorf("unknown key %s", fields.Tag)
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", name, line, err)
+		}
+	}
+	if err = s.Err(); err != nil {
+		return
+	}
+
+	if migrationLog != nil {
+		err = set(kMigrationLog, &migrationLog)
+	}
+	return
+}
+
+func importChunks(dir string, chunks Collection) (err error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return
+	}
+
+	var tileTypes = make(map[rune]TileType, len(tileRunes))
+	for t, r := range tileRunes {
+		tileTypes[r] = TileType(t)
+	}
+
+	for _, name := range names {
+		var c *Chunk
+		c, err = importChunk(name, tileTypes)
+		if err != nil {
+			return
+		}
+
+		var b []byte
+		b, err = objectToBytes(c)
+		if err != nil {
+			return
+		}
+		err = chunks.Set(c.ChunkCoord.bytes(), b)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func importChunk(name string, tileTypes map[rune]TileType) (c *Chunk, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	if !s.Scan() {
+		err = s.Err()
+		if err == nil {
+			err = fmt.Errorf("%s: empty file", name)
+		}
+		return
+	}
+	fields, err := parseTextFields(s.Text())
+	if err == nil && fields.Tag != "CHUNK" {
+		err = fmt.Errorf("expected CHUNK, not %s", fields.Tag)
+	}
+	if err != nil {
+		err = fmt.Errorf("%s:1: %v", name, err)
+		return
+	}
+	coord := fields.Ints("coord", 2, math.MinInt64, math.MaxInt64)
+	if err = fields.Done(); err != nil {
+		err = fmt.Errorf("%s:1: %v", name, err)
+		return
+	}
+
+	c = &Chunk{ChunkCoord: ChunkCoord{coord[0], coord[1]}}
+	for y := ChunkSize - 1; y >= 0; y-- {
+		line := ChunkSize - y + 1
+		if !s.Scan() {
+			err = s.Err()
+			if err == nil {
+				err = fmt.Errorf("%s:%d: expected %d rows of tiles", name, line, ChunkSize)
+			}
+			return
+		}
+		row := []rune(s.Text())
+		if len(row) != ChunkSize {
+			err = fmt.Errorf("%s:%d: expected %d tiles, not %d", name, line, ChunkSize, len(row))
+			return
+		}
+		for x, r := range row {
+			t, ok := tileTypes[r]
+			if !ok {
+				err = fmt.Errorf("%s:%d: unknown tile %q", name, line, r)
+				return
+			}
+			c.Tiles[x][y].Type = t
+		}
+	}
+	return
+}
+
+func importEntities(name string, entities Collection) (err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	ents, err := ParseEntities(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+
+	for _, ent := range ents {
+		var b []byte
+		b, err = encodeEntity(ent)
+		if err != nil {
+			return
+		}
+		err = entities.Set(ent.ID.bytes(), b)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func importTombstones(name string, tombstones Collection) (err error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		// exported before entities could be destroyed.
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	destroyed := make(map[EntityReference]Timestamp)
+	ents, err := parseEntityBlocks(f, func(f *textFields) (*Entity, error) {
+		if f.Tag != "TOMBSTONE" {
+			return nil, fmt.Errorf("expected TOMBSTONE, not %s", f.Tag)
+		}
+		ent := &Entity{ID: f.Entity("id")}
+		destroyed[ent.ID] = f.Time("destroyed")
+		return ent, f.Done()
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+
+	for _, ent := range ents {
+		t := tombstone{Destroyed: destroyed[ent.ID]}
+		t.Entity, err = encodeEntity(ent)
+		if err != nil {
+			return
+		}
+		var b []byte
+		b, err = objectToBytes(&t)
+		if err != nil {
+			return
+		}
+		err = tombstones.Set(ent.ID.bytes(), b)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func importHistory(name string, history Collection) (err error) {
+	b, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		// exported before the history was kept.
+		return nil
+	}
+	if err != nil {
+		return
+	}
+
+	events, err := parseHistoryEvents(string(b))
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	for _, e := range events {
+		if err = storeHistoryEvent(history, e); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func importSchedule(name string, schedule Collection) (err error) {
+	b, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		// exported before events could be scheduled.
+		return nil
+	}
+	if err != nil {
+		return
+	}
+
+	events, err := parseScheduledEvents(string(b))
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	for _, e := range events {
+		if err = storeScheduledEvent(schedule, e); err != nil {
+			return
+		}
+	}
+	return
+}
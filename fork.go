@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+var (
+	kForkParent = []byte("parent")
+	kForkTime   = []byte("forked")
+)
+
+// copyBackend copies every collection in src into dst.
+func copyBackend(dst, src Backend) (err error) {
+	for _, name := range src.CollectionNames() {
+		c := dst.Collection(name)
+		var setErr error
+		err = src.Collection(name).Visit(nil, func(k, v []byte) bool {
+			setErr = c.Set(k, v)
+			return setErr == nil
+		})
+		if err == nil {
+			err = setErr
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// forkStore copies the save in src to dst and records that it was forked
+// from the save named parent at the copy's current Timestamp.
+func forkStore(dst, src Backend, parent string) (err error) {
+	err = copyBackend(dst, src)
+	if err != nil {
+		return
+	}
+
+	global := dst.Collection("global")
+	err = global.Set(kForkParent, []byte(parent))
+	if err != nil {
+		return
+	}
+
+	t, err := global.Get(kTime)
+	if err != nil {
+		return
+	}
+	if len(t) != 8 {
+		t = make([]byte, 8)
+	}
+	err = global.Set(kForkTime, t)
+	if err != nil {
+		return
+	}
+
+	return dst.Flush()
+}
+
+// ForkParent returns the name of the save this one was forked from and the
+// Timestamp it was forked at, or "" if it was not forked.
+func (w *World) ForkParent() (parent string, at Timestamp, err error) {
+	p, err := w.global.Get(kForkParent)
+	if err != nil {
+		return
+	}
+	t, err := w.global.Get(kForkTime)
+	if err != nil {
+		return
+	}
+	parent = string(p)
+	if len(t) == 8 {
+		at = Timestamp(binary.BigEndian.Uint64(t))
+	}
+	return
+}
+
+// Fork copies the world, including chunks and entities that have not been
+// saved yet, to a new save called name.
+func (w *World) Fork(name string) (err error) {
+	dst, err := createSave(name)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err_ := dst.Close()
+		if err == nil {
+			err = err_
+		}
+		if err != nil {
+			os.Remove(saveFileName(name))
+		}
+	}()
+
+	w.Lock()
+	defer w.Unlock()
+
+	err = w.writeCaches()
+	if err != nil {
+		return
+	}
+
+	return forkStore(dst, w.store, w.name)
+}
+
+// forkSave copies a save that is not currently loaded.
+func forkSave(parent, name string) (err error) {
+	f, err := os.Open(saveFileName(parent))
+	if err != nil {
+		return
+	}
+	src, err := NewGkvliteBackend(f)
+	if err != nil {
+		f.Close()
+		return
+	}
+	defer src.Close()
+
+	dst, err := createSave(name)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err_ := dst.Close()
+		if err == nil {
+			err = err_
+		}
+		if err != nil {
+			os.Remove(saveFileName(name))
+		}
+	}()
+
+	return forkStore(dst, src, parent)
+}
+
+// forkName picks an unused name for a fork of the save called parent.
+func forkName(parent string) string {
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s fork %d", parent, i)
+		if _, err := os.Stat(saveFileName(name)); os.IsNotExist(err) {
+			return name
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// The graph command writes the relationships between entities as a Graphviz
+// DOT file, for example:
+//
+//	untitled-game graph -root 12 -depth 3 mysave | dot -Tsvg > mysave.svg
+//
+// Destroyed entities that are still referenced are drawn dashed.
+
+type graphOptions struct {
+	// chunk range, inclusive; used if chunks is set.
+	chunks         bool
+	x0, y0, x1, y1 int64
+
+	// root entity and how many edges away from it to go; used if root
+	// is not 0.
+	root  EntityReference
+	depth int
+
+	// time window [from, to); used if either is not 0. An entity is in
+	// the window if anything in its history happened during it.
+	from, to Timestamp
+}
+
+type graphEdge struct {
+	from, to EntityReference
+	label    string
+}
+
+func init() {
+	registerCommand("graph", "[-chunks x0,y0,x1,y1] [-root id] [-depth n] [-from time] [-to time] <save> [out.dot]", func(args []string) error {
+		var opts graphOptions
+		var chunks string
+		var root, from, to uint64
+		flags := flag.NewFlagSet("graph", flag.ContinueOnError)
+		flags.SetOutput(os.Stderr)
+		flags.StringVar(&chunks, "chunks", "", "only entities located in chunks x0,y0 through x1,y1")
+		flags.Uint64Var(&root, "root", 0, "only entities linked to this entity")
+		flags.IntVar(&opts.depth, "depth", 2, "how many links away from -root to go")
+		flags.Uint64Var(&from, "from", 0, "only entities with history at or after this time")
+		flags.Uint64Var(&to, "to", 0, "only entities with history before this time")
+		if err := flags.Parse(args); err != nil {
+			return errUsage
+		}
+		opts.root, opts.from, opts.to = EntityReference(root), Timestamp(from), Timestamp(to)
+		if chunks != "" {
+			opts.chunks = true
+			if _, err := fmt.Sscanf(chunks, "%d,%d,%d,%d", &opts.x0, &opts.y0, &opts.x1, &opts.y1); err != nil {
+				return errUsage
+			}
+		}
+		args = flags.Args()
+		if len(args) != 1 && len(args) != 2 {
+			return errUsage
+		}
+
+		w, err := openSave(args[0])
+		if err != nil {
+			return err
+		}
+		defer w.store.Close()
+
+		out := os.Stdout
+		if len(args) == 2 {
+			if out, err = os.Create(args[1]); err != nil {
+				return err
+			}
+			defer out.Close()
+		}
+		return writeGraph(w, opts, out)
+	})
+}
+
+// writeGraph writes the graph of the save in w's store to out. Anything in
+// w's caches must already be in the store, as it is after openSave.
+func writeGraph(w *World, opts graphOptions, out io.Writer) (err error) {
+	ents := make(map[EntityReference]*Entity)
+	destroyed := make(map[EntityReference]bool)
+	var visitErr error
+	err = w.entity.Visit(nil, func(k, v []byte) bool {
+		var ent *Entity
+		if ent, visitErr = decodeEntity(v); visitErr != nil {
+			return false
+		}
+		ents[ent.ID] = ent
+		return true
+	})
+	if err == nil {
+		err = visitErr
+	}
+	if err != nil {
+		return
+	}
+	err = w.tombstone.Visit(nil, func(k, v []byte) bool {
+		var t tombstone
+		var ent *Entity
+		if visitErr = bytesToObject(&t, v); visitErr != nil {
+			return false
+		}
+		if ent, visitErr = decodeEntity(t.Entity); visitErr != nil {
+			return false
+		}
+		ents[ent.ID] = ent
+		destroyed[ent.ID] = true
+		return true
+	})
+	if err == nil {
+		err = visitErr
+	}
+	if err != nil {
+		return
+	}
+
+	var edges []graphEdge
+	for _, ent := range ents {
+		edges = append(edges, entityEdges(ent)...)
+	}
+
+	include := make(map[EntityReference]bool)
+	for id := range ents {
+		include[id] = true
+	}
+
+	if opts.chunks {
+		x0, x1 := opts.x0, opts.x1
+		if x0 > x1 {
+			x0, x1 = x1, x0
+		}
+		y0, y1 := opts.y0, opts.y1
+		if y0 > y1 {
+			y0, y1 = y1, y0
+		}
+		for id, ent := range ents {
+			l := ent.location()
+			if l == nil || l.ChunkX < x0 || l.ChunkX > x1 || l.ChunkY < y0 || l.ChunkY > y1 {
+				delete(include, id)
+			}
+		}
+	}
+
+	if opts.from != 0 || opts.to != 0 {
+		var events []*HistoryEvent
+		if events, err = w.History(opts.from, opts.to); err != nil {
+			return
+		}
+		active := make(map[EntityReference]bool)
+		for _, e := range events {
+			for _, id := range e.Entities {
+				active[id] = true
+			}
+		}
+		for id := range include {
+			if !active[id] {
+				delete(include, id)
+			}
+		}
+	}
+
+	if opts.root != 0 {
+		if ents[opts.root] == nil {
+			return fmt.Errorf("entity %d does not exist", opts.root)
+		}
+		// links are followed in both directions.
+		near := map[EntityReference]bool{opts.root: true}
+		frontier := map[EntityReference]bool{opts.root: true}
+		for d := 0; d < opts.depth && len(frontier) != 0; d++ {
+			next := make(map[EntityReference]bool)
+			for _, e := range edges {
+				for _, pair := range [2][2]EntityReference{{e.from, e.to}, {e.to, e.from}} {
+					if frontier[pair[0]] && !near[pair[1]] {
+						near[pair[1]] = true
+						next[pair[1]] = true
+					}
+				}
+			}
+			frontier = next
+		}
+		for id := range include {
+			if !near[id] {
+				delete(include, id)
+			}
+		}
+	}
+
+	ids := make([]EntityReference, 0, len(include))
+	for id := range include {
+		ids = append(ids, id)
+	}
+	sort.Sort(entityReferences(ids))
+
+	buf := bufio.NewWriter(out)
+	fmt.Fprintln(buf, "digraph entities {")
+	fmt.Fprintln(buf, "\tnode [shape=box];")
+	for _, id := range ids {
+		label := fmt.Sprintf("#%d", id)
+		for _, c := range ents[id].Components {
+			label += "\\n" + componentID(c)
+		}
+		style := ""
+		if destroyed[id] {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(buf, "\te%d [label=%q%s];\n", id, label, style)
+	}
+	sort.Sort(graphEdges(edges))
+	for _, e := range edges {
+		if include[e.from] && include[e.to] {
+			fmt.Fprintf(buf, "\te%d -> e%d [label=%q];\n", e.from, e.to, e.label)
+		}
+	}
+	fmt.Fprintln(buf, "}")
+	return buf.Flush()
+}
+
+// entityEdges returns the links from ent to other entities.
+func entityEdges(ent *Entity) (edges []graphEdge) {
+	add := func(to EntityReference, label string) {
+		if to != 0 {
+			edges = append(edges, graphEdge{ent.ID, to, label})
+		}
+	}
+	for _, c := range ent.Components {
+		switch c := c.(type) {
+		case *OwnerComponent:
+			if c.End == 0 {
+				add(c.ID, fmt.Sprintf("OWNER %v-", c.Start))
+			} else {
+				add(c.ID, fmt.Sprintf("OWNER %v-%v", c.Start, c.End))
+			}
+		case *OwnerOfComponent:
+			add(c.ID, "OWNER_OF")
+		case *CreatedByComponent:
+			add(c.ID, "CREATED_BY")
+		case *CreatedComponent:
+			for _, m := range c.Material {
+				add(m, "CREATED material")
+			}
+		case *LocationComponent:
+			add(c.ID, "LOCATION")
+		}
+	}
+	return
+}
+
+type graphEdges []graphEdge
+
+func (s graphEdges) Len() int      { return len(s) }
+func (s graphEdges) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s graphEdges) Less(i, j int) bool {
+	if s[i].from != s[j].from {
+		return s[i].from < s[j].from
+	}
+	if s[i].to != s[j].to {
+		return s[i].to < s[j].to
+	}
+	return s[i].label < s[j].label
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// The history of the world is an append-only log in its own collection,
+// holding two kinds of keys:
+//
+//	't' Time Seq       -> the event
+//	'e' ID Time Seq    -> nothing; one for each entity in the event
+//
+// with every number big-endian. Seq comes from a counter in the global
+// collection, so events at the same Timestamp keep the order they happened
+// in.
+
+// HistoryEventType says what happened in a HistoryEvent, and what its
+// Entities are.
+type HistoryEventType string
+
+const (
+	// EventCreated: the new entity.
+	EventCreated HistoryEventType = "created"
+	// EventDestroyed: the destroyed entity.
+	EventDestroyed HistoryEventType = "destroyed"
+	// EventOwnership: the item, its old owner and its new owner. An
+	// owner is 0 if the item had none.
+	EventOwnership HistoryEventType = "ownership"
+	// EventCrafted: the product, its creator, where it was made, and then
+	// every material.
+	EventCrafted HistoryEventType = "crafted"
+)
+
+type HistoryEvent struct {
+	Time     Timestamp
+	Seq      uint64
+	Type     HistoryEventType
+	Entities []EntityReference
+}
+
+func (e *HistoryEvent) String() string {
+	return fmt.Sprintf("EVENT time[time]=%v seq[int]=%v type[text]=%q entities[entities]=%v", e.Time, e.Seq, string(e.Type), e.Entities)
+}
+
+func (e *HistoryEvent) parseText(f *textFields) error {
+	if f.Tag != "EVENT" {
+		return fmt.Errorf("expected EVENT, not %s", f.Tag)
+	}
+	e.Time = f.Time("time")
+	e.Seq = f.Uint("seq")
+	e.Type = HistoryEventType(f.Text("type"))
+	e.Entities = f.Entities("entities")
+	return f.Done()
+}
+
+// savedHistoryEvent is a HistoryEvent minus what its key holds.
+type savedHistoryEvent struct {
+	Type     HistoryEventType
+	Entities []EntityReference
+}
+
+var kHistorySeq = []byte("eventseq")
+
+func historyEventKey(t Timestamp, seq uint64) []byte {
+	b := make([]byte, 1+8+8)
+	b[0] = 't'
+	binary.BigEndian.PutUint64(b[1:], uint64(t))
+	binary.BigEndian.PutUint64(b[9:], seq)
+	return b
+}
+
+func historyEntityKey(id EntityReference, t Timestamp, seq uint64) []byte {
+	b := make([]byte, 1+8+8+8)
+	b[0] = 'e'
+	binary.BigEndian.PutUint64(b[1:], uint64(id))
+	binary.BigEndian.PutUint64(b[9:], uint64(t))
+	binary.BigEndian.PutUint64(b[17:], seq)
+	return b
+}
+
+// recordEvent appends an event at the current time to the history. The
+// caller must hold w's lock.
+func (w *World) recordEvent(typ HistoryEventType, entities ...EntityReference) error {
+	return w.recordEventAt(w.now(), typ, entities...)
+}
+
+// recordEventAt is recordEvent for an event that happened at t.
+func (w *World) recordEventAt(t Timestamp, typ HistoryEventType, entities ...EntityReference) (err error) {
+	seq, err := w.nextCounter(kHistorySeq)
+	if err != nil {
+		return
+	}
+
+	return storeHistoryEvent(w.history, &HistoryEvent{
+		Time:     t,
+		Seq:      seq,
+		Type:     typ,
+		Entities: entities,
+	})
+}
+
+func storeHistoryEvent(history Collection, e *HistoryEvent) (err error) {
+	b, err := objectToBytes(&savedHistoryEvent{Type: e.Type, Entities: e.Entities})
+	if err != nil {
+		return
+	}
+	if err = history.Set(historyEventKey(e.Time, e.Seq), b); err != nil {
+		return
+	}
+
+	indexed := make(map[EntityReference]bool)
+	for _, id := range e.Entities {
+		if id == 0 || indexed[id] {
+			continue
+		}
+		indexed[id] = true
+		if err = history.Set(historyEntityKey(id, e.Time, e.Seq), []byte{}); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (w *World) historyEvent(t Timestamp, seq uint64) (e *HistoryEvent, err error) {
+	b, err := w.history.Get(historyEventKey(t, seq))
+	if err != nil {
+		return
+	}
+	if b == nil {
+		return nil, fmt.Errorf("history event at %v (%d) is missing", t, seq)
+	}
+
+	var saved savedHistoryEvent
+	if err = bytesToObject(&saved, b); err != nil {
+		return
+	}
+	return &HistoryEvent{Time: t, Seq: seq, Type: saved.Type, Entities: saved.Entities}, nil
+}
+
+// History returns the events from start up to but not including end, oldest
+// first. An end of 0 means no end.
+func (w *World) History(start, end Timestamp) (events []*HistoryEvent, err error) {
+	w.Lock()
+	defer w.Unlock()
+
+	var keys [][]byte
+	err = w.history.Visit(historyEventKey(start, 0), func(k, v []byte) bool {
+		if k[0] != 't' || end != 0 && Timestamp(binary.BigEndian.Uint64(k[1:])) >= end {
+			return false
+		}
+		keys = append(keys, k)
+		return true
+	})
+	if err != nil {
+		return
+	}
+
+	for _, k := range keys {
+		var e *HistoryEvent
+		e, err = w.historyEvent(Timestamp(binary.BigEndian.Uint64(k[1:])), binary.BigEndian.Uint64(k[9:]))
+		if err != nil {
+			return
+		}
+		events = append(events, e)
+	}
+	return
+}
+
+// EntityHistory returns the events involving id from start up to but not
+// including end, oldest first. An end of 0 means no end.
+func (w *World) EntityHistory(id EntityReference, start, end Timestamp) (events []*HistoryEvent, err error) {
+	w.Lock()
+	defer w.Unlock()
+
+	prefix := historyEntityKey(id, 0, 0)[:9]
+	var keys [][]byte
+	err = w.history.Visit(historyEntityKey(id, start, 0), func(k, v []byte) bool {
+		if !bytes.HasPrefix(k, prefix) || end != 0 && Timestamp(binary.BigEndian.Uint64(k[9:])) >= end {
+			return false
+		}
+		keys = append(keys, k)
+		return true
+	})
+	if err != nil {
+		return
+	}
+
+	for _, k := range keys {
+		var e *HistoryEvent
+		e, err = w.historyEvent(Timestamp(binary.BigEndian.Uint64(k[9:])), binary.BigEndian.Uint64(k[17:]))
+		if err != nil {
+			return
+		}
+		events = append(events, e)
+	}
+	return
+}
+
+// parseHistoryEvents reads events written by HistoryEvent.String, one per
+// line. Blank lines and lines starting with # are ignored.
+func parseHistoryEvents(text string) (events []*HistoryEvent, err error) {
+	err = parseTextLines(text, func(f *textFields) error {
+		e := new(HistoryEvent)
+		events = append(events, e)
+		return e.parseText(f)
+	})
+	if err != nil {
+		events = nil
+	}
+	return
+}
@@ -4,6 +4,7 @@ package language
 
 import (
 	"github.com/BenLubar/untitled-game/chemical"
+	"strconv"
 	"strings"
 )
 
@@ -93,6 +94,9 @@ func Ordinal(i int) string {
 	if strings.HasSuffix(s, "five") {
 		return s[:len(s)-len("five")] + "fifth"
 	}
+	if strings.HasSuffix(s, "twelve") {
+		return s[:len(s)-len("twelve")] + "twelfth"
+	}
 	if strings.HasSuffix(s, "nine") {
 		return s[:len(s)-len("nine")] + "ninth"
 	}
@@ -112,3 +116,78 @@ func ChemName(c chemical.Chemical) string {
 	}
 	panic(c)
 }
+
+var seasonNames = [...]string{
+	"N/A",
+
+	"the thaw",
+	"early spring",
+	"midspring",
+	"late spring",
+
+	"the burn",
+	"early summer",
+	"midsummer",
+	"late summer",
+
+	"the fall",
+	"early autumn",
+	"midautumn",
+	"late autumn",
+
+	"the freeze",
+	"early winter",
+	"midwinter",
+	"late winter",
+	"year's end",
+}
+
+// Season returns the name of a season, numbered in calendar order from 1.
+// 0 is no season.
+func Season(season int) string {
+	return seasonNames[season]
+}
+
+var timeOfDayNames = [...]string{
+	"N/A",
+
+	"night",
+	"dawn",
+	"morning",
+	"afternoon",
+	"dusk",
+}
+
+// TimeOfDay returns the name of a time of day, numbered from 1 for night.
+// 0 is no time of day.
+func TimeOfDay(timeOfDay int) string {
+	return timeOfDayNames[timeOfDay]
+}
+
+// Date writes out a date, such as "the forty third day of midspring, year
+// twelve, at dusk". day is 0 for seasons that are only one day long.
+func Date(day, season, year, timeOfDay int) string {
+	s := Season(season)
+	if day != 0 {
+		s = "the " + Ordinal(day) + " day of " + s
+	}
+	return s + ", year " + Number(year) + ", at " + TimeOfDay(timeOfDay)
+}
+
+// Age writes out a length of time, such as "3 years, 12 days".
+func Age(years, days int) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return strconv.Itoa(n) + " " + unit + "s"
+	}
+	switch {
+	case years == 0:
+		return plural(days, "day")
+	case days == 0:
+		return plural(years, "year")
+	default:
+		return plural(years, "year") + ", " + plural(days, "day")
+	}
+}
@@ -76,3 +76,71 @@ func ChemName(c chemical.Chemical) string {
 	}
 	panic(c)
 }
+
+var seasonNames = [...]string{
+	"na'i",
+
+	"runme",
+	"cfa vensa",
+	"midju vensa",
+	"fanmo vensa",
+
+	"jelca",
+	"cfa crisa",
+	"midju crisa",
+	"fanmo crisa",
+
+	"farlu",
+	"cfa critu",
+	"midju critu",
+	"fanmo critu",
+
+	"dunja",
+	"cfa dunra",
+	"midju dunra",
+	"fanmo dunra",
+	"nanca fanmo",
+}
+
+// Season returns the name of a season, numbered in calendar order from 1.
+// 0 is no season.
+func Season(season int) string {
+	return seasonNames[season]
+}
+
+var timeOfDayNames = [...]string{
+	"na'i",
+
+	"nicte",
+	"solri cfari",
+	"cerni",
+	"donri",
+	"vanci",
+}
+
+// TimeOfDay returns the name of a time of day, numbered from 1 for night.
+// 0 is no time of day.
+func TimeOfDay(timeOfDay int) string {
+	return timeOfDayNames[timeOfDay]
+}
+
+// Date writes out a date. day is 0 for seasons that are only one day long.
+func Date(day, season, year, timeOfDay int) string {
+	s := "lo " + Season(season)
+	if day != 0 {
+		s = "lo " + Ordinal(day) + " djedi be " + s
+	}
+	return s + " pe lo " + Ordinal(year) + " nanca ca lo " + TimeOfDay(timeOfDay)
+}
+
+// Age writes out a length of time.
+func Age(years, days int) string {
+	switch {
+	case years == 0:
+		return Number(days) + " djedi"
+	case days == 0:
+		return Number(years) + " nanca"
+	default:
+		return Number(years) + " nanca " + Number(days) + " djedi"
+	}
+}
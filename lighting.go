@@ -0,0 +1,109 @@
+package main
+
+// Light is how brightly a tile is lit, from LightNone to LightFull.
+type Light uint8
+
+const (
+	LightNone Light = 0
+	LightFull Light = 15
+)
+
+// skyLight is the light the sky gives at each time of day. The nil time has
+// no time of day, so it is always fully lit.
+var skyLight = [timeOfDay_max]Light{
+	TimeOfDay_NA:        LightFull,
+	TimeOfDay_Night:     4,
+	TimeOfDay_Dawn:      10,
+	TimeOfDay_Morning:   LightFull,
+	TimeOfDay_Afternoon: LightFull,
+	TimeOfDay_Dusk:      10,
+}
+
+// SkyLight returns the light the sky gives at t.
+func (t Timestamp) SkyLight() Light {
+	return skyLight[t.TimeOfDay()]
+}
+
+// nightTicks is how long the night lasts. The sun is up for the rest of the
+// day, from the start of dawn to the end of dusk, and the moon is up at
+// night.
+const nightTicks = ts_ticks_per_day / 5
+
+// Sun returns how far the sun is along its path across the sky, from 0 as it
+// rises to 1 as it sets, and whether it is up at all.
+func (t Timestamp) Sun() (progress float64, up bool) {
+	if t == 0 {
+		return 0, false
+	}
+	tick := Timestamp(t.Tick() - 1)
+	if tick < nightTicks {
+		return 0, false
+	}
+	return float64(tick-nightTicks) / float64(ts_ticks_per_day-nightTicks), true
+}
+
+// Moon is like Sun, but for the moon.
+func (t Timestamp) Moon() (progress float64, up bool) {
+	if t == 0 {
+		return 0, false
+	}
+	tick := Timestamp(t.Tick() - 1)
+	if tick >= nightTicks {
+		return 0, false
+	}
+	return float64(tick) / float64(nightTicks), true
+}
+
+// Light from the sky falls straight down, losing one level for each tile
+// that is not air it passes through. The world is built around Y=0, so only
+// the tile's own chunk and the chunk above it are counted.
+
+// LightAt returns how brightly the tile at x, y is lit right now, and
+// whether it is open to the sky, with nothing but air above it.
+func (w *World) LightAt(x, y int64) (light Light, open bool, err error) {
+	coord := ChunkForTile(x, y)
+	c, err := w.RequestChunk(coord)
+	if err != nil {
+		return
+	}
+	defer w.ReleaseChunk(c)
+	above, err := w.RequestChunk(ChunkCoord{coord.X, coord.Y + 1})
+	if err != nil {
+		return
+	}
+	defer w.ReleaseChunk(above)
+
+	var shade [ChunkSize]int
+	c.RDo(func() {
+		above.RDo(func() {
+			columnShade(c, above, int(x&(ChunkSize-1)), &shade)
+		})
+	})
+	s := shade[y&(ChunkSize-1)]
+	return shadeLight(w.Time().SkyLight(), s), s == 0, nil
+}
+
+// columnShade sets shade to the number of tiles that are not air above each
+// tile in column x of c, counting the same column of above. The caller must
+// hold both chunks' read locks.
+func columnShade(c, above *Chunk, x int, shade *[ChunkSize]int) {
+	n := 0
+	for y := range above.Tiles[x] {
+		if above.Tiles[x][y].Type != TileAir {
+			n++
+		}
+	}
+	for y := ChunkSize - 1; y >= 0; y-- {
+		shade[y] = n
+		if c.Tiles[x][y].Type != TileAir {
+			n++
+		}
+	}
+}
+
+func shadeLight(sky Light, shade int) Light {
+	if shade >= int(sky) {
+		return LightNone
+	}
+	return sky - Light(shade)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"math"
 )
 
 type LocationComponent struct {
@@ -15,9 +16,42 @@ type LocationComponent struct {
 }
 
 func init() {
-	registerComponentType(&LocationComponent{})
+	registerComponentType(ComponentType{ID: "LOCATION", Version: 1, Single: true, Proto: &LocationComponent{}})
 }
 
 func (c *LocationComponent) String() string {
 	return fmt.Sprintf("LOCATION id[entity]=%v chunk[ints]=(%v,%v,%v) tile[ints]=(%v,%v,%v)", c.ID, c.ChunkX, c.ChunkY, c.ChunkZ, c.TileX, c.TileY, c.TileZ)
 }
+
+func (c *LocationComponent) parseText(f *textFields) error {
+	c.ID = f.Entity("id")
+	chunk := f.Ints("chunk", 3, math.MinInt64, math.MaxInt64)
+	c.ChunkX, c.ChunkY, c.ChunkZ = chunk[0], chunk[1], chunk[2]
+	tile := f.Ints("tile", 3, 0, math.MaxUint8)
+	c.TileX, c.TileY, c.TileZ = uint8(tile[0]), uint8(tile[1]), uint8(tile[2])
+	return f.Done()
+}
+
+// Location returns a copy of e's LocationComponent.
+func (e *Entity) Location() (loc LocationComponent, ok bool) {
+	e.RDo(func() {
+		if l := e.location(); l != nil {
+			loc, ok = *l, true
+		}
+	})
+	return
+}
+
+// SetLocation adds or replaces e's LocationComponent.
+func (e *Entity) SetLocation(loc LocationComponent) {
+	if err := e.Replace(&loc); err != nil {
+		panic(err)
+	}
+}
+
+// location returns e's LocationComponent, or nil if it has none. The caller
+// must hold e's lock.
+func (e *Entity) location() *LocationComponent {
+	l, _ := e.get("LOCATION").(*LocationComponent)
+	return l
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+// The location index is its own collection, so entities near the player can
+// be found without decoding every entity. It holds two kinds of keys:
+//
+//	'c' ChunkX ChunkY ChunkZ ID -> TileX TileY TileZ
+//	'e' ID                      -> the 'c' key currently indexed for ID
+//
+// with every number big-endian. It is updated whenever an entity is written
+// to the store, so the queries below also look at the cached entities,
+// which may have moved since they were last written.
+
+func locationIndexChunkPrefix(x, y int64) []byte {
+	b := make([]byte, 1+8+8)
+	b[0] = 'c'
+	binary.BigEndian.PutUint64(b[1:], uint64(x))
+	binary.BigEndian.PutUint64(b[9:], uint64(y))
+	return b
+}
+
+func locationIndexKey(id EntityReference, loc *LocationComponent) []byte {
+	b := make([]byte, 1+8+8+8+8)
+	copy(b, locationIndexChunkPrefix(loc.ChunkX, loc.ChunkY))
+	binary.BigEndian.PutUint64(b[17:], uint64(loc.ChunkZ))
+	binary.BigEndian.PutUint64(b[25:], uint64(id))
+	return b
+}
+
+func locationIndexEntityKey(id EntityReference) []byte {
+	return append([]byte{'e'}, id.bytes()...)
+}
+
+// updateLocationIndex records that id is at loc, or nowhere if loc is nil.
+func updateLocationIndex(index Collection, id EntityReference, loc *LocationComponent) (err error) {
+	ek := locationIndexEntityKey(id)
+	old, err := index.Get(ek)
+	if err != nil {
+		return
+	}
+
+	var key []byte
+	if loc != nil {
+		key = locationIndexKey(id, loc)
+	}
+
+	if old != nil && !bytes.Equal(old, key) {
+		if err = index.Delete(old); err != nil {
+			return
+		}
+	}
+
+	if loc == nil {
+		if old != nil {
+			err = index.Delete(ek)
+		}
+		return
+	}
+
+	if err = index.Set(key, []byte{loc.TileX, loc.TileY, loc.TileZ}); err != nil {
+		return
+	}
+	if !bytes.Equal(old, key) {
+		err = index.Set(ek, key)
+	}
+	return
+}
+
+// storeEntity writes ent to the store, updates the location index and marks
+// ent clean. The caller must hold w's lock and either hold ent's write lock
+// or be its only user.
+func (w *World) storeEntity(ent *Entity) (err error) {
+	b, err := encodeEntity(ent)
+	if err != nil {
+		return
+	}
+	if err = w.entity.Set(ent.ID.bytes(), b); err != nil {
+		return
+	}
+
+	var loc *LocationComponent
+	if l := ent.location(); l != nil {
+		copied := *l
+		loc = &copied
+	}
+	if err = updateLocationIndex(w.location, ent.ID, loc); err != nil {
+		return
+	}
+	ent.dirty = false
+	return
+}
+
+// EntitiesInChunk returns the entities whose LocationComponent is in the
+// given chunk, at any Z, in ascending order.
+func (w *World) EntitiesInChunk(coord ChunkCoord) ([]EntityReference, error) {
+	return w.EntitiesInRect(coord.X*ChunkSize, coord.Y*ChunkSize, coord.X*ChunkSize+ChunkSize-1, coord.Y*ChunkSize+ChunkSize-1)
+}
+
+// EntitiesInRect returns the entities whose LocationComponent is on a tile
+// between (x0, y0) and (x1, y1) inclusive, at any Z, in ascending order.
+// Tile coordinates are the same as those given to ChunkForTile.
+func (w *World) EntitiesInRect(x0, y0, x1, y1 int64) (ids []EntityReference, err error) {
+	if x0 > x1 {
+		x0, x1 = x1, x0
+	}
+	if y0 > y1 {
+		y0, y1 = y1, y0
+	}
+	inRect := func(chunkX, chunkY int64, tileX, tileY uint8) bool {
+		x, y := chunkX*ChunkSize+int64(tileX), chunkY*ChunkSize+int64(tileY)
+		return x >= x0 && x <= x1 && y >= y0 && y <= y1
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	found := make(map[EntityReference]bool)
+
+	min, max := ChunkForTile(x0, y0), ChunkForTile(x1, y1)
+	for cx := min.X; cx <= max.X; cx++ {
+		for cy := min.Y; cy <= max.Y; cy++ {
+			prefix := locationIndexChunkPrefix(cx, cy)
+			err = w.location.Visit(prefix, func(k, v []byte) bool {
+				if !bytes.HasPrefix(k, prefix) {
+					return false
+				}
+				if inRect(cx, cy, v[0], v[1]) {
+					found[EntityReference(binary.BigEndian.Uint64(k[25:]))] = true
+				}
+				return true
+			})
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	// cached entities may not match what was last indexed.
+	for id, ent := range w.entities {
+		ent.RDo(func() {
+			l := ent.location()
+			found[id] = l != nil && inRect(l.ChunkX, l.ChunkY, l.TileX, l.TileY)
+		})
+	}
+
+	for id, ok := range found {
+		if ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(entityReferences(ids))
+	return
+}
+
+type entityReferences []EntityReference
+
+func (s entityReferences) Len() int           { return len(s) }
+func (s entityReferences) Less(i, j int) bool { return s[i] < s[j] }
+func (s entityReferences) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// rebuildLocationIndex indexes every stored entity from scratch.
+func rebuildLocationIndex(entities, index Collection) (err error) {
+	var stale [][]byte
+	err = index.Visit(nil, func(k, v []byte) bool {
+		stale = append(stale, k)
+		return true
+	})
+	if err != nil {
+		return
+	}
+	for _, k := range stale {
+		if err = index.Delete(k); err != nil {
+			return
+		}
+	}
+
+	var ents []*Entity
+	var visitErr error
+	err = entities.Visit(nil, func(k, v []byte) bool {
+		var ent *Entity
+		if ent, visitErr = decodeEntity(v); visitErr != nil {
+			return false
+		}
+		ents = append(ents, ent)
+		return true
+	})
+	if err == nil {
+		err = visitErr
+	}
+	if err != nil {
+		return
+	}
+
+	for _, ent := range ents {
+		if err = updateLocationIndex(index, ent.ID, ent.location()); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func init() {
+	registerMigration(2, "location index", func(w *World) error {
+		return rebuildLocationIndex(w.entity, w.location)
+	})
+}
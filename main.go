@@ -1,13 +1,28 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/davecheney/profile"
 	"github.com/nsf/termbox-go"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
+var autosaveInterval = flag.Duration("autosave", 5*time.Minute, "how often to save the world in the background (0 disables autosave)")
+
 func main() {
+	flag.Parse()
+
+	if flag.NArg() != 0 {
+		runCommand(flag.Args())
+		return
+	}
+
 	defer profile.Start(&profile.Config{
 		Quiet:       true,
 		CPUProfile:  true,
@@ -21,9 +36,11 @@ func main() {
 	}
 	defer termbox.Close()
 
+	// This also runs when the game panics, so everything in the World
+	// caches makes it to disk.
 	defer func() {
 		if world := GetWorld(); world != nil {
-			if err := world.store.Flush(); err != nil {
+			if err := world.Save(); err != nil {
 				panic(err)
 			}
 		}
@@ -32,9 +49,13 @@ func main() {
 	termbox.SetInputMode(termbox.InputEsc | termbox.InputMouse)
 
 	repaint := time.Tick(time.Second / 60)
+	autosave := time.Tick(*autosaveInterval)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 
+	// where the player was when the last frame was drawn.
 	var playerX, playerY int64
-	var nextPlayerX, nextPlayerY int64
 
 	events := make(chan termbox.Event)
 	go pollEvents(events)
@@ -49,16 +70,33 @@ func main() {
 					if !mainMenu.inputKey(e.Key, e.Ch, e.Mod) {
 						return
 					}
+				} else if debugConsole.open {
+					debugConsole.inputKey(world, e.Key, e.Ch)
 				} else {
-					switch e.Key {
-					case termbox.KeyArrowDown:
-						nextPlayerY = playerY - 1
-					case termbox.KeyArrowUp:
-						nextPlayerY = playerY + 1
-					case termbox.KeyArrowLeft:
-						nextPlayerX = playerX - 1
-					case termbox.KeyArrowRight:
-						nextPlayerX = playerX + 1
+					switch {
+					case e.Ch == '`':
+						debugConsole.open = true
+					case e.Key == termbox.KeySpace:
+						world.TogglePause()
+					case e.Ch >= '1' && int(e.Ch-'1') < len(gameSpeeds):
+						world.SetSpeed(gameSpeeds[e.Ch-'1'])
+					case e.Key == termbox.KeyArrowDown:
+						world.MovePlayer(0, -1)
+					case e.Key == termbox.KeyArrowUp:
+						world.MovePlayer(0, 1)
+					case e.Key == termbox.KeyArrowLeft:
+						world.MovePlayer(-1, 0)
+					case e.Key == termbox.KeyArrowRight:
+						world.MovePlayer(1, 0)
+					case e.Ch == 'f':
+						name := forkName(world.name)
+						if err := world.Fork(name); err != nil {
+							log.Printf("error forking world: %v", err)
+							world.Notify("fork failed")
+						} else {
+							mainMenu.saveNames = append(mainMenu.saveNames, name)
+							world.Notify(fmt.Sprintf("forked as %q", name))
+						}
 					default:
 						// TODO: game UI
 						panic(fmt.Sprintf("%v, %v, %v", e.Key, e.Ch, e.Mod))
@@ -75,6 +113,19 @@ func main() {
 				// ignore
 			}
 
+		case <-autosave:
+			if world := GetWorld(); world != nil {
+				go func() {
+					if err := world.Save(); err != nil {
+						log.Printf("error saving world: %v", err)
+					}
+				}()
+			}
+
+		case <-signals:
+			// the deferred Save above writes the world before we exit.
+			return
+
 		case <-repaint:
 			termbox.Clear(termbox.ColorWhite, termbox.ColorBlack)
 
@@ -84,7 +135,7 @@ func main() {
 				mainMenu.render(w, h)
 			} else {
 				oldPlayerX, oldPlayerY := playerX, playerY
-				playerX, playerY = nextPlayerX, nextPlayerY
+				playerX, playerY = world.PlayerPosition()
 				if oldMid, newMid := ChunkForTile(oldPlayerX, oldPlayerY), ChunkForTile(playerX, playerY); oldMid != newMid {
 					for i := int64(-1); i <= int64(1); i++ {
 						for j := int64(-1); j <= int64(1); j++ {
@@ -102,10 +153,14 @@ func main() {
 						}
 					}
 				}
-				world.Tick()
+				world.Frame()
 				renderWorld(playerX, playerY, w, h, world)
+				renderSky(w, h, world)
 				// TODO: game UI
 				renderBorder(w, h, world)
+				if debugConsole.open {
+					debugConsole.render(w, h)
+				}
 			}
 			termbox.Flush()
 		}
@@ -121,20 +176,57 @@ func renderWorld(playerX, playerY int64, w, h int, world *World) {
 	}
 }
 
+// skyColor is the color of air open to the sky at each time of day.
+var skyColor = [timeOfDay_max]termbox.Attribute{
+	TimeOfDay_NA:        termbox.ColorBlack,
+	TimeOfDay_Night:     termbox.ColorBlack,
+	TimeOfDay_Dawn:      termbox.ColorMagenta,
+	TimeOfDay_Morning:   termbox.ColorCyan,
+	TimeOfDay_Afternoon: termbox.ColorCyan,
+	TimeOfDay_Dusk:      termbox.ColorMagenta,
+}
+
+// Tiles lit at least lightBright are drawn in full color. Darker tiles are
+// drawn as colored text on black, and tiles darker than lightDim in dim
+// text.
+const (
+	lightBright Light = 8
+	lightDim    Light = 4
+)
+
 func renderChunk(coord ChunkCoord, startX, startY int, world *World) {
 	c, err := world.RequestChunk(coord)
 	if err != nil {
 		panic(err)
 	}
 	defer world.ReleaseChunk(c)
+	above, err := world.RequestChunk(ChunkCoord{coord.X, coord.Y + 1})
+	if err != nil {
+		panic(err)
+	}
+	defer world.ReleaseChunk(above)
 
+	t := world.Time()
+	sky := t.SkyLight()
+
+	// no tile may change while the chunk is drawn.
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	above.mtx.RLock()
+	defer above.mtx.RUnlock()
+
+	var shade [ChunkSize]int
 	for x := range c.Tiles {
+		columnShade(c, above, x, &shade)
 		for y := range c.Tiles[x] {
 			var color termbox.Attribute
 			var text []rune
 			switch c.Tiles[x][y].Type {
 			case TileAir:
 				color = termbox.ColorBlack
+				if shade[y] == 0 {
+					color = skyColor[t.TimeOfDay()]
+				}
 				text = []rune(" air ")
 			case TileDirt:
 				color = termbox.ColorYellow
@@ -152,11 +244,45 @@ func renderChunk(coord ChunkCoord, startX, startY int, world *World) {
 				color = termbox.ColorBlue
 				text = []rune(" water ")
 			}
-			termbox.SetCell(startX+x, startY-y, text[((coord.X*ChunkSize+int64(x)+coord.Y*ChunkSize+int64(y))%int64(len(text))+int64(len(text)))%int64(len(text))], termbox.AttrBold|color, color)
+			fg, bg := termbox.AttrBold|color, color
+			switch light := shadeLight(sky, shade[y]); {
+			case light < lightDim:
+				fg, bg = color, termbox.ColorBlack
+			case light < lightBright:
+				bg = termbox.ColorBlack
+			}
+			termbox.SetCell(startX+x, startY-y, text[((coord.X*ChunkSize+int64(x)+coord.Y*ChunkSize+int64(y))%int64(len(text))+int64(len(text)))%int64(len(text))], fg, bg)
 		}
 	}
 }
 
+// skyRows is how many rows at the top of the screen the sun and moon arc
+// across.
+const skyRows = 4
+
+// renderSky draws the sun or the moon, rising on the left of the screen and
+// setting on the right.
+func renderSky(w, h int, world *World) {
+	t := world.Time()
+	glyph, fg := '☼', termbox.ColorYellow|termbox.AttrBold
+	progress, up := t.Sun()
+	if !up {
+		glyph, fg = '☾', termbox.ColorWhite|termbox.AttrBold
+		progress, up = t.Moon()
+	}
+	if !up || w < 3 || h < 3 {
+		return
+	}
+
+	rows := skyRows
+	if rows > h-2 {
+		rows = h - 2
+	}
+	x := 1 + int(progress*float64(w-3)+0.5)
+	y := 1 + int((1-math.Sin(progress*math.Pi))*float64(rows-1)+0.5)
+	termbox.SetCell(x, y, glyph, fg, skyColor[t.TimeOfDay()])
+}
+
 func renderBorder(w, h int, world *World) {
 	t := world.Time()
 
@@ -176,23 +302,28 @@ func renderBorder(w, h int, world *World) {
 		termbox.SetCell(w-x, 0, '╞', termbox.ColorBlack, termbox.ColorWhite)
 	}
 
-	// always use at least four digits for the year
-	for year := t.Year(); year != 0 || x < 4+2; year /= 10 {
-		x++
-		termbox.SetCell(w-x, 0, '0'+rune(year%10), termbox.ColorBlack, termbox.ColorWhite)
+	date := []rune(t.Date())
+	for i, ch := range date {
+		termbox.SetCell(w-x-len(date)+i, 0, ch, termbox.ColorBlack, termbox.ColorWhite)
 	}
+	x += len(date)
 	divider()
-	season := t.Season().String()
-	for i, ch := range season {
-		termbox.SetCell(w-x-len(season)+i, 0, ch, termbox.ColorBlack, termbox.ColorWhite)
+	speed := world.SpeedText()
+	for i, ch := range speed {
+		termbox.SetCell(w-x-len(speed)+i, 0, ch, termbox.ColorBlack, termbox.ColorWhite)
 	}
-	x += len(season)
-	divider()
-	tod := t.TimeOfDay().String()
-	for i, ch := range tod {
-		termbox.SetCell(w-x-len(tod)+i, 0, ch, termbox.ColorBlack, termbox.ColorWhite)
+	x += len(speed)
+	if status, failed := world.SaveStatus(); status != "" {
+		divider()
+		fg := termbox.ColorBlack
+		if failed {
+			fg = termbox.ColorRed
+		}
+		for i, ch := range status {
+			termbox.SetCell(w-x-len(status)+i, 0, ch, fg, termbox.ColorWhite)
+		}
+		x += len(status)
 	}
-	x += len(tod)
 
 	x++
 	termbox.SetCell(w-x, 0, '╡', termbox.ColorBlack, termbox.ColorWhite)
@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"github.com/nsf/termbox-go"
-	"github.com/steveyen/gkvlite"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -14,6 +13,25 @@ import (
 
 const SaveDirName = "saves_5CC9DB70-EEC5-47EA-94B6-398BFC12E4A7"
 
+func saveFileName(name string) string {
+	return filepath.Join(SaveDirName, name+".sav")
+}
+
+// createSave creates a new, empty save file. It fails if the save exists.
+func createSave(name string) (Backend, error) {
+	_ = os.MkdirAll(SaveDirName, 0777)
+	f, err := os.OpenFile(saveFileName(name), os.O_CREATE|os.O_EXCL|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	store, err := NewGkvliteBackend(f)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	return store, err
+}
+
 var mainMenu mainMenuUI
 
 func init() {
@@ -46,6 +64,7 @@ const (
 	menuStateMain = iota
 	menuStateError
 	menuStateNew
+	menuStateFork
 )
 
 type mainMenuUI struct {
@@ -55,9 +74,30 @@ type mainMenuUI struct {
 	state       uint
 	saveName    []rune
 	seed        []rune
+	forkParent  string
+	summaries   map[string]string
 	err         string
 }
 
+// summary describes a save for the load list. It reads only the save's
+// metadata, so listing saves does not load any worlds.
+func (m *mainMenuUI) summary(name string) string {
+	if s, ok := m.summaries[name]; ok {
+		return s
+	}
+	if m.summaries == nil {
+		m.summaries = make(map[string]string)
+	}
+
+	meta, err := ReadSaveMeta(name)
+	if err != nil {
+		m.summaries[name] = ""
+	} else {
+		m.summaries[name] = meta.Summary()
+	}
+	return m.summaries[name]
+}
+
 func (m *mainMenuUI) render(w, h int) {
 	m.drawTextFlicker(w, 2, "5CC9DB70-EEC5-47EA-94B6-398BFC12E4A7", termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack)
 
@@ -70,10 +110,14 @@ func (m *mainMenuUI) render(w, h int) {
 		}
 
 		for i, name := range m.saveNames[skip:] {
+			text := fmt.Sprintf("Load %q", name)
+			if summary := m.summary(name); summary != "" {
+				text += " - " + summary
+			}
 			if m.choiceIndex == i+skip {
-				m.drawText(w, 5+i, fmt.Sprintf("Load %q", name), termbox.ColorBlack, termbox.ColorWhite)
+				m.drawText(w, 5+i, text, termbox.ColorBlack, termbox.ColorWhite)
 			} else {
-				m.drawText(w, 5+i, fmt.Sprintf("Load %q", name), termbox.ColorWhite, termbox.ColorBlack)
+				m.drawText(w, 5+i, text, termbox.ColorWhite, termbox.ColorBlack)
 			}
 		}
 		if m.choiceIndex == len(m.saveNames) {
@@ -81,6 +125,9 @@ func (m *mainMenuUI) render(w, h int) {
 		} else {
 			m.drawText(w, len(m.saveNames)+5-skip, "New Game", termbox.ColorWhite, termbox.ColorBlack)
 		}
+		if m.choiceIndex < len(m.saveNames) {
+			m.drawText(w, h-2, "F: fork this save", termbox.ColorWhite, termbox.ColorBlack)
+		}
 
 	case menuStateError:
 		m.drawText(w, 5, m.err, termbox.ColorRed, termbox.ColorBlack)
@@ -99,6 +146,10 @@ func (m *mainMenuUI) render(w, h int) {
 		} else {
 			m.drawText(w, 9, string(m.seed), termbox.ColorWhite, termbox.ColorBlack)
 		}
+
+	case menuStateFork:
+		m.drawText(w, 5, fmt.Sprintf("Fork %q as", m.forkParent), termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack)
+		m.drawText(w, 6, string(m.saveName)+"_", termbox.ColorBlack, termbox.ColorWhite)
 	}
 }
 
@@ -155,6 +206,10 @@ func (m *mainMenuUI) inputKey(key termbox.Key, ch rune, mod termbox.Modifier) bo
 					m.newGame()
 				}
 			}
+		case (ch == 'f' || ch == 'F') && m.choiceIndex < len(m.saveNames):
+			m.forkParent = m.saveNames[m.choiceIndex]
+			m.saveName = []rune(forkName(m.forkParent))
+			m.state = menuStateFork
 		case key == termbox.KeyEsc:
 			return false
 		default:
@@ -186,31 +241,22 @@ func (m *mainMenuUI) inputKey(key termbox.Key, ch rune, mod termbox.Modifier) bo
 					fmt.Print("\a")
 					return true
 				}
-				_ = os.MkdirAll(SaveDirName, 0777)
-				f, err := os.OpenFile(filepath.Join(SaveDirName, string(m.saveName)+".sav"), os.O_CREATE|os.O_EXCL|os.O_RDWR, 0666)
+				store, err := createSave(string(m.saveName))
 				var w *World
 				if err == nil {
-					var store *gkvlite.Store
-					store, err = gkvlite.NewStore(f)
-					w = &World{store: store, storeFile: f}
-				}
-				if err == nil {
-					err = w.setSeed(NewSeed(string(m.seed)))
-				}
-
-				if err == nil {
-					err = w.init()
+					w, err = NewWorld(store, string(m.seed))
+					if err != nil {
+						store.Close()
+					}
 				}
 
 				if err == nil {
+					w.name = string(m.saveName)
 					m.saveNames = append(m.saveNames, string(m.saveName))
 					worldLock.Lock()
 					world = w
 					worldLock.Unlock()
 				} else {
-					if f != nil {
-						f.Close()
-					}
 					m.err = err.Error()
 					m.state = menuStateError
 				}
@@ -254,6 +300,48 @@ func (m *mainMenuUI) inputKey(key termbox.Key, ch rune, mod termbox.Modifier) bo
 			panic(fmt.Sprintf("%v, %v, %v", key, ch, mod))
 		}
 
+	case menuStateFork:
+		switch {
+		case key == termbox.KeyEsc:
+			m.state = menuStateMain
+		case key == termbox.KeyEnter:
+			if len(m.saveName) == 0 {
+				fmt.Print("\a")
+				return true
+			}
+			if err := forkSave(m.forkParent, string(m.saveName)); err != nil {
+				m.err = err.Error()
+				m.state = menuStateError
+				return true
+			}
+			m.saveNames = append(m.saveNames, string(m.saveName))
+			m.choiceIndex = len(m.saveNames) - 1
+			m.state = menuStateMain
+		case key == termbox.KeyArrowUp || key == termbox.KeyArrowDown || key == termbox.KeyArrowLeft || key == termbox.KeyArrowRight:
+			fmt.Print("\a")
+		case key == termbox.KeyBackspace || key == termbox.KeyBackspace2:
+			if len(m.saveName) == 0 {
+				fmt.Print("\a")
+			} else {
+				m.saveName = m.saveName[:len(m.saveName)-1]
+			}
+		case key == termbox.KeySpace:
+			if len(m.saveName) == 0 {
+				fmt.Print("\a")
+			} else {
+				m.saveName = append(m.saveName, ' ')
+			}
+		case ch != 0:
+			// unicode.Punctuation is not included due to characters like /
+			if ch != '_' && ch != '-' && !unicode.In(ch, unicode.Letter, unicode.Number, unicode.Symbol) {
+				fmt.Print("\a")
+			} else {
+				m.saveName = append(m.saveName, ch)
+			}
+		default:
+			panic(fmt.Sprintf("%v, %v, %v", key, ch, mod))
+		}
+
 	default:
 		panic(fmt.Sprintf("%v, %v, %v", key, ch, mod))
 	}
@@ -274,33 +362,32 @@ func (m *mainMenuUI) inputMouse(x, y int) {
 }
 
 func (m *mainMenuUI) loadGame(name string) {
-	var w World
-
-	f, err := os.OpenFile(filepath.Join(SaveDirName, name+".sav"), os.O_RDWR, 0666)
+	f, err := os.OpenFile(saveFileName(name), os.O_RDWR, 0666)
 	if err != nil {
 		m.err = err.Error()
 		m.state = menuStateError
 		return
 	}
-	w.store, err = gkvlite.NewStore(f)
+	store, err := NewGkvliteBackend(f)
 	if err != nil {
 		m.err = err.Error()
 		m.state = menuStateError
 		f.Close()
 		return
 	}
-	w.storeFile = f
 
-	err = w.init()
+	w, err := LoadWorld(store)
 	if err != nil {
 		m.err = err.Error()
 		m.state = menuStateError
-		f.Close()
+		store.Close()
 		return
 	}
 
+	w.name = name
+
 	worldLock.Lock()
-	world = &w
+	world = w
 	worldLock.Unlock()
 }
 
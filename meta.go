@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"time"
+)
+
+// SaveMeta is a summary of a save that can be read without loading the
+// world. It is written to the global collection every time the world is
+// saved.
+type SaveMeta struct {
+	SeedText string
+	Created  time.Time     // zero for saves made before metadata existed
+	PlayTime time.Duration // total time the world has been loaded
+	Time     Timestamp
+	PlayerX  int64
+	PlayerY  int64
+	Version  uint64
+}
+
+var kMeta = []byte("meta")
+
+func (w *World) PlayerPosition() (x, y int64) {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.playerX, w.playerY
+}
+
+func (w *World) MovePlayer(dx, dy int64) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.playerX += dx
+	w.playerY += dy
+}
+
+func (w *World) SetPlayerPosition(x, y int64) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.playerX, w.playerY = x, y
+}
+
+// loadMeta restores the player position from the save and starts counting
+// play time.
+func (w *World) loadMeta() (err error) {
+	meta, err := readSaveMeta(w.global)
+	if err != nil {
+		return
+	}
+	w.playerX, w.playerY = meta.PlayerX, meta.PlayerY
+	w.playStart = time.Now()
+	return
+}
+
+// writeMeta updates the metadata and the world clock in the store. The
+// caller must hold w's lock.
+func (w *World) writeMeta() (err error) {
+	if err = w.global.Set(kTime, w.Time().bytes()); err != nil {
+		return
+	}
+
+	meta, err := readSaveMeta(w.global)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	if !w.playStart.IsZero() {
+		meta.PlayTime += now.Sub(w.playStart)
+	}
+	w.playStart = now
+	meta.PlayerX, meta.PlayerY = w.playerX, w.playerY
+
+	return w.setMeta(meta)
+}
+
+func (w *World) setMeta(meta *SaveMeta) (err error) {
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(meta)
+	if err != nil {
+		return
+	}
+	err = w.global.Set(kMeta, buf.Bytes())
+	return
+}
+
+// readSaveMeta reads the metadata in a global collection. The seed, time and
+// version always come from their own keys, which are kept up to date even
+// by code that does not know about the metadata.
+func readSaveMeta(global Collection) (meta *SaveMeta, err error) {
+	meta = new(SaveMeta)
+
+	b, err := global.Get(kMeta)
+	if err != nil {
+		return
+	}
+	if len(b) != 0 {
+		err = gob.NewDecoder(bytes.NewReader(b)).Decode(meta)
+		if err != nil {
+			return
+		}
+	}
+
+	if b, err = global.Get(kSeed); err != nil {
+		return
+	}
+	if len(b) != 0 {
+		var seed Seed
+		if err = gob.NewDecoder(bytes.NewReader(b)).Decode(&seed); err != nil {
+			return
+		}
+		meta.SeedText = seed.Text
+	}
+
+	if b, err = global.Get(kTime); err != nil {
+		return
+	}
+	if len(b) == 8 {
+		meta.Time = Timestamp(binary.BigEndian.Uint64(b))
+	}
+
+	if b, err = global.Get(kVersion); err != nil {
+		return
+	}
+	if len(b) == 8 {
+		meta.Version = binary.BigEndian.Uint64(b)
+	}
+	return
+}
+
+// ReadSaveMeta reads the metadata of a save that is not loaded, without
+// generating or migrating anything.
+func ReadSaveMeta(name string) (meta *SaveMeta, err error) {
+	f, err := os.Open(saveFileName(name))
+	if err != nil {
+		return
+	}
+	store, err := NewGkvliteBackend(f)
+	if err != nil {
+		f.Close()
+		return
+	}
+	defer store.Close()
+
+	return readSaveMeta(store.Collection("global"))
+}
+
+// Summary describes the save for the main menu, e.g.
+// "year 12, midspring, 3h played".
+func (meta *SaveMeta) Summary() string {
+	var s string
+	if meta.Time == 0 {
+		s = "not started"
+	} else {
+		s = fmt.Sprintf("year %d, %s", meta.Time.Year(), meta.Time.Season())
+	}
+
+	if played := meta.PlayTime; played >= time.Hour {
+		s += fmt.Sprintf(", %dh played", played/time.Hour)
+	} else {
+		s += fmt.Sprintf(", %dm played", played/time.Minute)
+	}
+
+	if meta.Version < CurrentSaveVersion {
+		s += fmt.Sprintf(", save version %d", meta.Version)
+	}
+	return s
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// A migration upgrades a save from one version to the next. Migrations are
+// registered by the version they upgrade from and are run in order by
+// World.init, so a version 1 save is taken through 1→2, 2→3, and so on until
+// it reaches CurrentSaveVersion.
+type migration struct {
+	Name string
+	Run  func(w *World) error
+}
+
+var migrations = make(map[uint64]migration)
+
+// registerMigration must be called from an init function. from is the save
+// version the migration expects; after it succeeds, the save is at from+1.
+func registerMigration(from uint64, name string, run func(w *World) error) {
+	if from == 0 || from >= CurrentSaveVersion {
+		panic(fmt.Sprintf("migration %q from version %d is out of range (current version is %d)", name, from, CurrentSaveVersion))
+	}
+	if m, ok := migrations[from]; ok {
+		panic(fmt.Sprintf("migration %q from version %d conflicts with %q", name, from, m.Name))
+	}
+	migrations[from] = migration{Name: name, Run: run}
+}
+
+// MigrationLogEntry records one migration step that was applied to a save.
+type MigrationLogEntry struct {
+	From, To uint64
+	Name     string
+	When     time.Time
+}
+
+var kMigrationLog = []byte("migrations")
+
+func (w *World) MigrationLog() (entries []MigrationLogEntry, err error) {
+	b, err := w.global.Get(kMigrationLog)
+	if err != nil || len(b) == 0 {
+		return
+	}
+
+	err = gob.NewDecoder(bytes.NewReader(b)).Decode(&entries)
+	return
+}
+
+func (w *World) appendMigrationLog(entry MigrationLogEntry) (err error) {
+	entries, err := w.MigrationLog()
+	if err != nil {
+		return
+	}
+	entries = append(entries, entry)
+
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(&entries)
+	if err != nil {
+		return
+	}
+
+	err = w.global.Set(kMigrationLog, buf.Bytes())
+	return
+}
+
+func (w *World) migrate(version uint64) (err error) {
+	for v := version; v < CurrentSaveVersion; v++ {
+		if _, ok := migrations[v]; !ok {
+			return fmt.Errorf("no migration from save version %d", v)
+		}
+	}
+
+	err = w.backupSave(version)
+	if err != nil {
+		log.Printf("error backing up save: %v", err)
+		return
+	}
+
+	versionBuf := make([]byte, 8)
+	for ; version < CurrentSaveVersion; version++ {
+		m := migrations[version]
+
+		log.Printf("migrating save from version %d to %d: %s", version, version+1, m.Name)
+		err = m.Run(w)
+		if err != nil {
+			return fmt.Errorf("migrating save from version %d to %d (%s): %v", version, version+1, m.Name, err)
+		}
+
+		binary.BigEndian.PutUint64(versionBuf, version+1)
+		err = w.global.Set(kVersion, versionBuf)
+		if err != nil {
+			return
+		}
+
+		err = w.appendMigrationLog(MigrationLogEntry{
+			From: version,
+			To:   version + 1,
+			Name: m.Name,
+			When: time.Now(),
+		})
+		if err != nil {
+			return
+		}
+
+		// flush after every step so a crash leaves the save at a version
+		// we know how to continue from.
+		err = w.store.Flush()
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// backupSave copies the save file to a sibling file before the first
+// migration step touches it. An existing backup is left alone, as it was
+// made before an earlier, interrupted attempt and is the better copy. The
+// copy is written under a temporary name and renamed once it is complete,
+// so a backup that exists is never partial.
+func (w *World) backupSave(version uint64) (err error) {
+	fb, ok := w.store.(interface {
+		File() *os.File
+	})
+	if !ok {
+		// nothing on disk to back up.
+		return
+	}
+	storeFile := fb.File()
+
+	fi, err := storeFile.Stat()
+	if err != nil {
+		return
+	}
+
+	name := fmt.Sprintf("%s.v%d.bak", storeFile.Name(), version)
+	if _, err = os.Stat(name); err == nil {
+		log.Printf("keeping existing backup %q", name)
+		return
+	} else if !os.IsNotExist(err) {
+		return
+	}
+
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+
+	if _, err = io.Copy(f, io.NewSectionReader(storeFile, 0, fi.Size())); err != nil {
+		return
+	}
+	if err = f.Sync(); err != nil {
+		return
+	}
+	err = f.Close()
+	f = nil
+	if err != nil {
+		return
+	}
+
+	err = os.Rename(tmp, name)
+	return
+}
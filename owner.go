@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"sort"
 )
 
 type OwnerOfComponent struct {
@@ -9,13 +10,18 @@ type OwnerOfComponent struct {
 }
 
 func init() {
-	registerComponentType(&OwnerOfComponent{})
+	registerComponentType(ComponentType{ID: "OWNER_OF", Version: 1, Proto: &OwnerOfComponent{}})
 }
 
 func (c *OwnerOfComponent) String() string {
 	return fmt.Sprintf("OWNER_OF id[entity]=%v", c.ID)
 }
 
+func (c *OwnerOfComponent) parseText(f *textFields) error {
+	c.ID = f.Entity("id")
+	return f.Done()
+}
+
 type OwnerComponent struct {
 	ID    EntityReference
 	Start Timestamp
@@ -23,9 +29,246 @@ type OwnerComponent struct {
 }
 
 func init() {
-	registerComponentType(&OwnerComponent{})
+	registerComponentType(ComponentType{ID: "OWNER", Version: 1, Proto: &OwnerComponent{}})
 }
 
 func (c *OwnerComponent) String() string {
 	return fmt.Sprintf("OWNER id[entity]=%v start[time]=%v end[time]=%v", c.ID, c.Start, c.End)
 }
+
+func (c *OwnerComponent) parseText(f *textFields) error {
+	c.ID = f.Entity("id")
+	c.Start = f.Time("start")
+	c.End = f.Time("end")
+	return f.Done()
+}
+
+// OwnerOf returns the entities e owns or has owned, according to its
+// OWNER_OF components.
+func (e *Entity) OwnerOf() (ids []EntityReference) {
+	for _, c := range e.GetAll("OWNER_OF") {
+		ids = append(ids, c.(*OwnerOfComponent).ID)
+	}
+	return
+}
+
+// Owners returns copies of e's OWNER components.
+func (e *Entity) Owners() (owners []OwnerComponent) {
+	e.RDo(func() {
+		for _, c := range e.Components {
+			if o, ok := c.(*OwnerComponent); ok {
+				owners = append(owners, *o)
+			}
+		}
+	})
+	return
+}
+
+// TransferOwnership makes to the owner of item, or leaves item unowned if to
+// is 0. item's open OWNER record is ended at the current time and a new one
+// is started, and to gets an OWNER_OF record for item if it has never owned
+// it before. OWNER_OF records are kept after ownership ends, so they list
+// everything an entity has ever owned. It is an error to transfer item while
+// the clock is before the start of its current ownership, as after the clock
+// has been moved back. Either every change is made or none is.
+func (w *World) TransferOwnership(item, to EntityReference) (err error) {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.transferOwnership(item, to, w.now())
+}
+
+// transferOwnership is TransferOwnership as of at. The caller must hold w's
+// lock.
+func (w *World) transferOwnership(item, to EntityReference, at Timestamp) (err error) {
+	if item == to {
+		return fmt.Errorf("entity %d cannot own itself", item)
+	}
+
+	itemEnt, err := w.requestEntity(item)
+	if err != nil {
+		return
+	}
+	defer w.releaseEntity(itemEnt)
+
+	var toEnt *Entity
+	if to != 0 {
+		if toEnt, err = w.requestEntity(to); err != nil {
+			return
+		}
+		defer w.releaseEntity(toEnt)
+	}
+
+	var current *OwnerComponent
+	itemEnt.RDo(func() {
+		for _, c := range itemEnt.Components {
+			if o, ok := c.(*OwnerComponent); ok && o.End == 0 {
+				current = o
+			}
+		}
+	})
+	if current == nil && to == 0 || current != nil && current.ID == to {
+		return
+	}
+
+	var from EntityReference
+	if current != nil {
+		if at < current.Start {
+			return fmt.Errorf("entity %d has been owned by entity %d since %v, after %v", item, current.ID, current.Start, at)
+		}
+		from = current.ID
+	}
+	if err = w.recordEventAt(at, EventOwnership, item, from, to); err != nil {
+		return
+	}
+
+	itemEnt.Do(func() {
+		if current != nil {
+			current.End = at
+		}
+		if to != 0 {
+			itemEnt.Components = append(itemEnt.Components, &OwnerComponent{ID: to, Start: at})
+		}
+	})
+	if toEnt != nil {
+		toEnt.Do(func() {
+			for _, c := range toEnt.Components {
+				if o, ok := c.(*OwnerOfComponent); ok && o.ID == item {
+					return
+				}
+			}
+			toEnt.Components = append(toEnt.Components, &OwnerOfComponent{ID: item})
+		})
+	}
+	return
+}
+
+// OwnerAt returns the owner of item at t, or 0 if it had none. Destroyed
+// entities can be looked up too.
+func (w *World) OwnerAt(item EntityReference, t Timestamp) (owner EntityReference, err error) {
+	w.Lock()
+	defer w.Unlock()
+
+	err = w.viewEntity(item, func(ent *Entity, destroyed Timestamp) {
+		for _, c := range ent.Components {
+			if o, ok := c.(*OwnerComponent); ok && t.Between(o.Start, o.End) {
+				owner = o.ID
+			}
+		}
+	})
+	return
+}
+
+// OwnedDuring returns the entities owner owned at any time from start up to
+// but not including end, in ascending order. An end of 0 means no end.
+func (w *World) OwnedDuring(owner EntityReference, start, end Timestamp) (owned []EntityReference, err error) {
+	w.Lock()
+	defer w.Unlock()
+
+	var everOwned []EntityReference
+	err = w.viewEntity(owner, func(ent *Entity, destroyed Timestamp) {
+		for _, c := range ent.Components {
+			if o, ok := c.(*OwnerOfComponent); ok {
+				everOwned = append(everOwned, o.ID)
+			}
+		}
+	})
+	if err != nil {
+		return
+	}
+
+	for _, item := range everOwned {
+		err = w.viewEntity(item, func(ent *Entity, destroyed Timestamp) {
+			for _, c := range ent.Components {
+				if o, ok := c.(*OwnerComponent); ok && o.ID == owner && o.during(start, end) {
+					owned = append(owned, item)
+					return
+				}
+			}
+		})
+		if err != nil {
+			return
+		}
+	}
+	sort.Sort(entityReferences(owned))
+	return
+}
+
+// OwnedInYear returns the entities owner owned at any time during the given
+// year, as numbered by Timestamp.Year.
+func (w *World) OwnedInYear(owner EntityReference, year uint64) ([]EntityReference, error) {
+	if year == 0 || year > uint64(ts_max_years) {
+		return nil, nil
+	}
+	start, _ := ts_min.Add(Years(year - 1))
+	// the last year ends with time itself.
+	end, _ := start.Add(Years(1))
+	return w.OwnedDuring(owner, start, end)
+}
+
+// during returns true if the ownership overlaps [start, end), where an end
+// of 0 means no end.
+func (c *OwnerComponent) during(start, end Timestamp) bool {
+	return (end == 0 || c.Start < end) && (c.End == 0 || c.End > start)
+}
+
+// ExpireOwnership schedules item's current owner to lose it at t. Nothing
+// happens at t if item has changed hands or been destroyed by then.
+func (w *World) ExpireOwnership(item EntityReference, t Timestamp) (err error) {
+	ent, err := w.RequestEntity(item)
+	if err != nil {
+		return
+	}
+	current, ok := ent.currentOwner()
+	w.ReleaseEntity(ent)
+
+	if !ok {
+		return fmt.Errorf("entity %d has no owner", item)
+	}
+	if t < current.Start {
+		return fmt.Errorf("entity %d has only been owned since %v", item, current.Start)
+	}
+	_, err = w.Schedule(t, "ownership expires", []EntityReference{item, current.ID}, nil)
+	return
+}
+
+// currentOwner returns a copy of e's open OWNER record, if it has one.
+func (e *Entity) currentOwner() (owner OwnerComponent, ok bool) {
+	e.RDo(func() {
+		for _, c := range e.Components {
+			if o, isOwner := c.(*OwnerComponent); isOwner && o.End == 0 {
+				owner, ok = *o, true
+			}
+		}
+	})
+	return
+}
+
+func init() {
+	// The ownership ends when the event was due, even if the clock has
+	// been moved past it since.
+	registerScheduleHandler("ownership expires", func(w *World, e *ScheduledEvent) error {
+		if len(e.Targets) != 2 {
+			return fmt.Errorf("expected an item and its owner, not %v", e.Targets)
+		}
+		item, owner := e.Targets[0], e.Targets[1]
+
+		w.Lock()
+		defer w.Unlock()
+
+		ent, err := w.requestEntity(item)
+		if err == ErrEntityDestroyed {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		current, ok := ent.currentOwner()
+		w.releaseEntity(ent)
+
+		if !ok || current.ID != owner || current.Start > e.Time {
+			return nil
+		}
+		return w.transferOwnership(item, 0, e.Time)
+	})
+}
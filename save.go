@@ -0,0 +1,118 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// how long "saved" stays in the border after a save finishes.
+const saveStatusLinger = 3 * time.Second
+
+type saveStatus struct {
+	saving bool
+	last   time.Time
+	err    error
+
+	note     string
+	noteTime time.Time
+
+	// running is held for the whole of a save, so saves happen one at a
+	// time.
+	running sync.Mutex
+
+	sync.Mutex
+}
+
+// Save writes every modified chunk and every entity held in the World caches
+// to the store and flushes it. Only one save runs at a time. A call made
+// while another save is in progress waits for it to finish and then saves
+// again, so everything changed before the call is written when it returns.
+func (w *World) Save() (err error) {
+	w.saveStatus.running.Lock()
+	defer w.saveStatus.running.Unlock()
+
+	w.saveStatus.Lock()
+	w.saveStatus.saving = true
+	w.saveStatus.Unlock()
+
+	defer func() {
+		w.saveStatus.Lock()
+		w.saveStatus.saving = false
+		w.saveStatus.last = time.Now()
+		w.saveStatus.err = err
+		w.saveStatus.Unlock()
+	}()
+
+	w.Lock()
+	defer w.Unlock()
+
+	err = w.writeCaches()
+	if err != nil {
+		return
+	}
+
+	err = w.store.Flush()
+	return
+}
+
+// writeCaches writes the save metadata, modified chunks and every cached
+// entity to the store without flushing it.
+func (w *World) writeCaches() (err error) {
+	err = w.writeMeta()
+	if err != nil {
+		return
+	}
+
+	for _, c := range w.chunks {
+		// not Do, which would mark the chunk dirty again.
+		c.mtx.Lock()
+		if c.dirty {
+			err = w.storeChunk(c)
+		}
+		c.mtx.Unlock()
+		if err != nil {
+			return
+		}
+	}
+
+	for _, ent := range w.entities {
+		// not Do, which would mark the entity dirty again.
+		ent.mtx.Lock()
+		if ent.dirty {
+			err = w.storeEntity(ent)
+		}
+		ent.mtx.Unlock()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// SaveStatus returns a short description of the most recent save for the
+// border, or "" if there is nothing worth showing.
+func (w *World) SaveStatus() (status string, failed bool) {
+	w.saveStatus.Lock()
+	defer w.saveStatus.Unlock()
+
+	switch {
+	case w.saveStatus.saving:
+		return "saving", false
+	case w.saveStatus.err != nil:
+		return "save failed", true
+	case w.saveStatus.note != "" && time.Since(w.saveStatus.noteTime) < saveStatusLinger:
+		return w.saveStatus.note, false
+	case !w.saveStatus.last.IsZero() && time.Since(w.saveStatus.last) < saveStatusLinger:
+		return "saved", false
+	}
+	return "", false
+}
+
+// Notify shows a short message where the save status goes in the border.
+func (w *World) Notify(note string) {
+	w.saveStatus.Lock()
+	defer w.saveStatus.Unlock()
+
+	w.saveStatus.note = note
+	w.saveStatus.noteTime = time.Now()
+}
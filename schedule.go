@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+)
+
+// Scheduled events are kept in their own collection, keyed by Time and Seq
+// like the events in the history, so the collection itself is the priority
+// queue: the first key is always the next event due, and events due at the
+// same Timestamp run in the order they were scheduled.
+
+type ScheduledEvent struct {
+	Time    Timestamp
+	Seq     uint64
+	Type    string
+	Targets []EntityReference
+	Data    []byte // for the handler to use as it likes
+}
+
+func (e *ScheduledEvent) String() string {
+	return fmt.Sprintf("SCHEDULED time[time]=%v seq[int]=%v type[text]=%q targets[entities]=%v data[bytes]=%x", e.Time, e.Seq, e.Type, e.Targets, e.Data)
+}
+
+func (e *ScheduledEvent) parseText(f *textFields) error {
+	if f.Tag != "SCHEDULED" {
+		return fmt.Errorf("expected SCHEDULED, not %s", f.Tag)
+	}
+	e.Time = f.Time("time")
+	e.Seq = f.Uint("seq")
+	e.Type = f.Text("type")
+	e.Targets = f.Entities("targets")
+	e.Data = f.Bytes("data")
+	return f.Done()
+}
+
+// savedScheduledEvent is a ScheduledEvent minus what its key holds.
+type savedScheduledEvent struct {
+	Type    string
+	Targets []EntityReference
+	Data    []byte
+}
+
+// scheduleHandlers run scheduled events, by type. They are called with
+// nothing locked. The clock may have been moved past e.Time since the event
+// was due, so anything they record should happen at e.Time rather than now.
+var scheduleHandlers = make(map[string]func(w *World, e *ScheduledEvent) error)
+
+func registerScheduleHandler(typ string, run func(w *World, e *ScheduledEvent) error) {
+	if _, ok := scheduleHandlers[typ]; ok {
+		panic(fmt.Sprintf("duplicate scheduled event type %q", typ))
+	}
+	scheduleHandlers[typ] = run
+}
+
+var kScheduleSeq = []byte("schedseq")
+
+func scheduleKey(t Timestamp, seq uint64) []byte {
+	b := make([]byte, 8+8)
+	binary.BigEndian.PutUint64(b, uint64(t))
+	binary.BigEndian.PutUint64(b[8:], seq)
+	return b
+}
+
+// Schedule queues an event of the given type to run on the first tick at or
+// after at. It returns the event's sequence number, which Unschedule needs.
+func (w *World) Schedule(at Timestamp, typ string, targets []EntityReference, data []byte) (seq uint64, err error) {
+	if _, ok := scheduleHandlers[typ]; !ok {
+		return 0, fmt.Errorf("unknown scheduled event type %q", typ)
+	}
+	if at == 0 {
+		return 0, fmt.Errorf("cannot schedule an event at the nil time")
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if seq, err = w.nextCounter(kScheduleSeq); err != nil {
+		return
+	}
+
+	err = storeScheduledEvent(w.schedule, &ScheduledEvent{Time: at, Seq: seq, Type: typ, Targets: targets, Data: data})
+	return
+}
+
+// ScheduleIn queues an event to run d ticks from now.
+func (w *World) ScheduleIn(d Duration, typ string, targets []EntityReference, data []byte) (at Timestamp, seq uint64, err error) {
+	at, ok := w.now().Add(d)
+	if !ok {
+		return 0, 0, fmt.Errorf("%v from now is past the end of time", d)
+	}
+	seq, err = w.Schedule(at, typ, targets, data)
+	return
+}
+
+// Unschedule removes an event from the queue. Removing an event that has
+// already run or was never scheduled does nothing.
+func (w *World) Unschedule(at Timestamp, seq uint64) error {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.schedule.Delete(scheduleKey(at, seq))
+}
+
+func storeScheduledEvent(schedule Collection, e *ScheduledEvent) error {
+	b, err := objectToBytes(&savedScheduledEvent{Type: e.Type, Targets: e.Targets, Data: e.Data})
+	if err != nil {
+		return err
+	}
+	return schedule.Set(scheduleKey(e.Time, e.Seq), b)
+}
+
+// Scheduled returns the queued events due from start up to but not
+// including end, soonest first. An end of 0 means no end.
+func (w *World) Scheduled(start, end Timestamp) (events []*ScheduledEvent, err error) {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.scheduled(start, end)
+}
+
+func (w *World) scheduled(start, end Timestamp) (events []*ScheduledEvent, err error) {
+	var visitErr error
+	err = w.schedule.Visit(scheduleKey(start, 0), func(k, v []byte) bool {
+		t := Timestamp(binary.BigEndian.Uint64(k))
+		if end != 0 && t >= end {
+			return false
+		}
+		var e *ScheduledEvent
+		if e, visitErr = decodeScheduledEvent(k, v); visitErr != nil {
+			return false
+		}
+		events = append(events, e)
+		return true
+	})
+	if err == nil {
+		err = visitErr
+	}
+	return
+}
+
+func decodeScheduledEvent(k, v []byte) (*ScheduledEvent, error) {
+	var saved savedScheduledEvent
+	if err := bytesToObject(&saved, v); err != nil {
+		return nil, err
+	}
+	return &ScheduledEvent{
+		Time:    Timestamp(binary.BigEndian.Uint64(k)),
+		Seq:     binary.BigEndian.Uint64(k[8:]),
+		Type:    saved.Type,
+		Targets: saved.Targets,
+		Data:    saved.Data,
+	}, nil
+}
+
+// runSchedule runs every queued event due at or before now, in order.
+// Events are taken off the queue one at a time, so an event that a handler
+// schedules runs before any later event already queued. Each event is
+// removed before it runs, so an event that panics does not run again when
+// the game is loaded.
+func (w *World) runSchedule(now Timestamp) {
+	for {
+		w.Lock()
+		e, err := w.popScheduled(now)
+		w.Unlock()
+		if err != nil {
+			panic(err)
+		}
+		if e == nil {
+			return
+		}
+
+		run, ok := scheduleHandlers[e.Type]
+		if !ok {
+			log.Printf("dropping scheduled event of unknown type: %v", e)
+			continue
+		}
+		if err := run(w, e); err != nil {
+			log.Printf("error running scheduled event %v: %v", e, err)
+		}
+	}
+}
+
+// popScheduled removes the first queued event and returns it if it is due
+// at or before now. It returns nil if no event is due.
+func (w *World) popScheduled(now Timestamp) (e *ScheduledEvent, err error) {
+	var visitErr error
+	err = w.schedule.Visit(scheduleKey(0, 0), func(k, v []byte) bool {
+		if Timestamp(binary.BigEndian.Uint64(k)) <= now {
+			e, visitErr = decodeScheduledEvent(k, v)
+		}
+		return false
+	})
+	if err == nil {
+		err = visitErr
+	}
+	if err != nil || e == nil {
+		return nil, err
+	}
+	err = w.schedule.Delete(scheduleKey(e.Time, e.Seq))
+	return
+}
+
+// parseScheduledEvents reads events written by ScheduledEvent.String, one
+// per line. Blank lines and lines starting with # are ignored.
+func parseScheduledEvents(text string) (events []*ScheduledEvent, err error) {
+	err = parseTextLines(text, func(f *textFields) error {
+		e := new(ScheduledEvent)
+		events = append(events, e)
+		return e.parseText(f)
+	})
+	if err != nil {
+		events = nil
+	}
+	return
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// System is gameplay code that World.Tick runs over entities. Systems see
+// every entity in the world, not just the ones in the cache: on ticks where
+// any system is due, stored entities are loaded for the length of the tick,
+// so the world changes the same way whichever entities the player happens
+// to be near.
+type System struct {
+	// Name identifies the system in timing stats.
+	Name string
+
+	// Order decides when the system runs in a tick; lower runs first.
+	// Systems with the same Order run in Name order.
+	Order int
+
+	// Interval is how many ticks pass between runs. The system runs on
+	// ticks where the Timestamp minus 1 is a multiple of Interval. 0 is
+	// the same as 1.
+	Interval uint64
+
+	// Components lists the component IDs an entity must have for Run to
+	// be called with it. If it is empty, Run is called for every entity.
+	Components []string
+
+	// Run is called once per matching entity, in ascending ID order, with
+	// nothing locked. Tick holds a reference to ent until every system
+	// has run.
+	Run func(w *World, ent *Entity, now Timestamp)
+}
+
+// SystemStats describes how long a system has taken to run.
+type SystemStats struct {
+	Name     string
+	Runs     uint64        // ticks the system ran on
+	Entities uint64        // calls to Run, over every tick
+	Total    time.Duration // time spent in Run, over every tick
+	Last     time.Duration // time spent in Run on the last tick it ran
+	Max      time.Duration // longest time spent in Run on one tick
+}
+
+var systems []*System
+
+func registerSystem(s System) {
+	if s.Run == nil {
+		panic(fmt.Sprintf("system %q: Run is nil", s.Name))
+	}
+	for _, other := range systems {
+		if other.Name == s.Name {
+			panic(fmt.Sprintf("system %q: name already used", s.Name))
+		}
+	}
+	for _, id := range s.Components {
+		if _, ok := componentTypesByID[id]; !ok {
+			panic(fmt.Sprintf("system %q: unknown component %q", s.Name, id))
+		}
+	}
+	if s.Interval == 0 {
+		s.Interval = 1
+	}
+
+	systems = append(systems, &s)
+	sort.Sort(systemOrder(systems))
+}
+
+type systemOrder []*System
+
+func (s systemOrder) Len() int      { return len(s) }
+func (s systemOrder) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s systemOrder) Less(i, j int) bool {
+	if s[i].Order != s[j].Order {
+		return s[i].Order < s[j].Order
+	}
+	return s[i].Name < s[j].Name
+}
+
+type systemStats struct {
+	stats map[string]*SystemStats
+	sync.Mutex
+}
+
+func (s *systemStats) record(name string, entities uint64, d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.stats == nil {
+		s.stats = make(map[string]*SystemStats)
+	}
+	st := s.stats[name]
+	if st == nil {
+		st = &SystemStats{Name: name}
+		s.stats[name] = st
+	}
+	st.Runs++
+	st.Entities += entities
+	st.Total += d
+	st.Last = d
+	if d > st.Max {
+		st.Max = d
+	}
+}
+
+// SystemStats returns the timing stats of every system that has run in this
+// session, in the order the systems run.
+func (w *World) SystemStats() (stats []SystemStats) {
+	w.systemStats.Lock()
+	defer w.systemStats.Unlock()
+
+	for _, s := range systems {
+		if st := w.systemStats.stats[s.Name]; st != nil {
+			stats = append(stats, *st)
+		}
+	}
+	return
+}
+
+// runSystems runs the systems due at now over every entity in the world.
+func (w *World) runSystems(now Timestamp) {
+	var due []*System
+	for _, s := range systems {
+		if uint64(now-ts_min)%s.Interval == 0 {
+			due = append(due, s)
+		}
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	w.Lock()
+	ents, err := w.requestAllEntities()
+	w.Unlock()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, s := range due {
+		var count uint64
+		start := time.Now()
+		for _, ent := range ents {
+			if ent.hasAll(s.Components) {
+				s.Run(w, ent, now)
+				count++
+			}
+		}
+		w.systemStats.record(s.Name, count, time.Since(start))
+	}
+
+	for _, ent := range ents {
+		w.ReleaseEntity(ent)
+	}
+}
+
+// requestAllEntities requests every entity in the world, cached or stored,
+// in ascending ID order. Each must be released. The caller must hold w's
+// lock.
+func (w *World) requestAllEntities() (ents []*Entity, err error) {
+	ids := make(entityReferences, 0, len(w.entities))
+	for id := range w.entities {
+		ids = append(ids, id)
+	}
+	err = w.entity.Visit(nil, func(k, v []byte) bool {
+		if id := EntityReference(binary.BigEndian.Uint64(k)); w.entities[id] == nil {
+			ids = append(ids, id)
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+	sort.Sort(ids)
+
+	ents = make([]*Entity, 0, len(ids))
+	for _, id := range ids {
+		var ent *Entity
+		if ent, err = w.requestEntity(id); err != nil {
+			for _, ent := range ents {
+				w.releaseEntity(ent)
+			}
+			return nil, err
+		}
+		ents = append(ents, ent)
+	}
+	return
+}
+
+func (e *Entity) hasAll(ids []string) (has bool) {
+	e.RDo(func() {
+		for _, id := range ids {
+			if e.get(id) == nil {
+				return
+			}
+		}
+		has = true
+	})
+	return
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"container/list"
 	"encoding/binary"
 	"log"
+	"sync"
 )
 
 const chunkShift = 8
@@ -27,6 +29,48 @@ type Chunk struct {
 	ChunkCoord
 	Tiles      [ChunkSize][ChunkSize]Tile
 	references uint
+	dirty      bool          // modified since it was last written to the store
+	retained   *list.Element // in World.retained while unreferenced
+	mtx        sync.RWMutex  // guards Tiles and dirty; saves run in the background
+}
+
+// Do calls f with c locked for writing, for changing Tiles directly. The
+// chunk is assumed to have been changed, so it is saved again.
+func (c *Chunk) Do(f func()) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.dirty = true
+	f()
+}
+
+func (c *Chunk) RDo(f func()) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	f()
+}
+
+// SetTile changes a tile and marks the chunk as needing to be saved. It must
+// not be called from f in Do or RDo.
+func (c *Chunk) SetTile(x, y int, t Tile) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.Tiles[x][y] != t {
+		c.Tiles[x][y] = t
+		c.dirty = true
+	}
+}
+
+// MarkDirty marks the chunk as needing to be saved even though its tiles
+// have not changed, so it is written at the next save, or when it is
+// evicted from the cache if that comes first.
+func (c *Chunk) MarkDirty() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.dirty = true
 }
 
 type Tile struct {
@@ -51,7 +95,7 @@ func (w *World) generateChunk(coord ChunkCoord) (c *Chunk, err error) {
 		return
 	}
 
-	c = &Chunk{ChunkCoord: coord}
+	c = &Chunk{ChunkCoord: coord, dirty: true}
 	// TODO: more interesting worldgen than "flat ground with lumps"
 	for x := range c.Tiles {
 		fx := float64(coord.X) + float64(x)/float64(ChunkSize)
@@ -82,3 +126,36 @@ func (w *World) generateChunk(coord ChunkCoord) (c *Chunk, err error) {
 	}
 	return
 }
+
+// RegenerateChunk replaces the tiles of a chunk with freshly generated ones,
+// undoing any changes made to it. Entities in the chunk are not touched.
+func (w *World) RegenerateChunk(coord ChunkCoord) error {
+	w.Lock()
+	defer w.Unlock()
+
+	fresh, err := w.generateChunk(coord)
+	if err != nil {
+		return err
+	}
+
+	if c := w.chunks[coord]; c != nil {
+		if c.references == 0 {
+			// nobody holds the retained chunk, so nothing will release it
+			// and write it; write it now.
+			c.Tiles = fresh.Tiles
+			return w.storeChunk(c)
+		}
+		// others are holding the cached chunk, so change it in place. It
+		// is written when the last of them releases it.
+		c.Do(func() {
+			c.Tiles = fresh.Tiles
+		})
+		return nil
+	}
+
+	b, err := objectToBytes(fresh)
+	if err != nil {
+		return err
+	}
+	return w.chunk.Set(coord.bytes(), b)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// textFields is one line of the typed key/value text format the String
+// methods of components write, such as
+//
+//	LOCATION id[entity]=5 chunk[ints]=(1,2,0) tile[ints]=(3,4,0)
+//
+// The getters record the first error they run into, so a parser can read
+// every field it needs and check done once at the end.
+type textFields struct {
+	Tag    string
+	fields map[string]textField
+	order  []string
+	err    error
+}
+
+type textField struct {
+	Type  string
+	Value string
+}
+
+// parseTextLines calls parse with each line of text, such as an exported
+// file with one record per line. Blank lines and lines starting with # are
+// skipped. Errors are prefixed with the line number.
+func parseTextLines(text string, parse func(f *textFields) error) error {
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f, err := parseTextFields(line)
+		if err == nil {
+			err = parse(f)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+func parseTextFields(line string) (*textFields, error) {
+	f := &textFields{fields: make(map[string]textField)}
+
+	line = strings.TrimSpace(line)
+	if i := strings.IndexByte(line, ' '); i == -1 {
+		f.Tag, line = line, ""
+	} else {
+		f.Tag, line = line[:i], strings.TrimLeft(line[i:], " ")
+	}
+	if f.Tag == "" {
+		return nil, fmt.Errorf("missing tag")
+	}
+
+	for line != "" {
+		open := strings.IndexByte(line, '[')
+		if open <= 0 {
+			return nil, fmt.Errorf("%s: expected key[type]=value at %q", f.Tag, line)
+		}
+		key := line[:open]
+		line = line[open+1:]
+
+		end := strings.Index(line, "]=")
+		if end == -1 {
+			return nil, fmt.Errorf("%s: unterminated type for %q", f.Tag, key)
+		}
+		typ := line[:end]
+		line = line[end+len("]="):]
+
+		var value string
+		switch {
+		case strings.HasPrefix(line, "("), strings.HasPrefix(line, "["):
+			closer := ")"
+			if line[0] == '[' {
+				closer = "]"
+			}
+			end := strings.Index(line, closer)
+			if end == -1 {
+				return nil, fmt.Errorf("%s: unterminated value for %q", f.Tag, key)
+			}
+			value, line = line[:end+1], line[end+1:]
+		case strings.HasPrefix(line, `"`):
+			q, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s: bad string for %q: %v", f.Tag, key, err)
+			}
+			value, line = q, line[len(q):]
+		default:
+			end := strings.IndexByte(line, ' ')
+			if end == -1 {
+				end = len(line)
+			}
+			value, line = line[:end], line[end:]
+		}
+
+		if line != "" && line[0] != ' ' {
+			return nil, fmt.Errorf("%s: expected space after %q", f.Tag, key)
+		}
+		line = strings.TrimLeft(line, " ")
+
+		if _, ok := f.fields[key]; ok {
+			return nil, fmt.Errorf("%s: duplicate key %q", f.Tag, key)
+		}
+		f.fields[key] = textField{Type: typ, Value: value}
+		f.order = append(f.order, key)
+	}
+
+	return f, nil
+}
+
+func (f *textFields) get(key, typ string) (string, bool) {
+	if f.err != nil {
+		return "", false
+	}
+	v, ok := f.fields[key]
+	if !ok {
+		f.err = fmt.Errorf("%s: missing %s[%s]", f.Tag, key, typ)
+		return "", false
+	}
+	if v.Type != typ {
+		f.err = fmt.Errorf("%s: %s has type %s, expected %s", f.Tag, key, v.Type, typ)
+		return "", false
+	}
+	delete(f.fields, key)
+	return v.Value, true
+}
+
+func (f *textFields) fail(key string, err error) {
+	if f.err == nil {
+		f.err = fmt.Errorf("%s: %s: %v", f.Tag, key, err)
+	}
+}
+
+func (f *textFields) Int(key string, min, max int64) int64 {
+	v, ok := f.get(key, "int")
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err == nil && (n < min || n > max) {
+		err = fmt.Errorf("%d is out of range [%d, %d]", n, min, max)
+	}
+	if err != nil {
+		f.fail(key, err)
+	}
+	return n
+}
+
+func (f *textFields) Uint(key string) uint64 {
+	v, ok := f.get(key, "int")
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		f.fail(key, err)
+	}
+	return n
+}
+
+func (f *textFields) Entity(key string) EntityReference {
+	v, ok := f.get(key, "entity")
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		f.fail(key, err)
+	}
+	return EntityReference(n)
+}
+
+func (f *textFields) Time(key string) Timestamp {
+	v, ok := f.get(key, "time")
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		f.fail(key, err)
+	}
+	return Timestamp(n)
+}
+
+// Ints reads a parenthesized, comma-separated list of exactly n integers,
+// each of which must be within [min, max].
+func (f *textFields) Ints(key string, n int, min, max int64) []int64 {
+	v, ok := f.get(key, "ints")
+	if !ok {
+		return make([]int64, n)
+	}
+	if !strings.HasPrefix(v, "(") || !strings.HasSuffix(v, ")") {
+		f.fail(key, fmt.Errorf("expected (...), not %q", v))
+		return make([]int64, n)
+	}
+	parts := strings.Split(v[1:len(v)-1], ",")
+	if len(parts) != n {
+		f.fail(key, fmt.Errorf("expected %d values, not %d", n, len(parts)))
+		return make([]int64, n)
+	}
+	ints := make([]int64, n)
+	for i, p := range parts {
+		var err error
+		ints[i], err = strconv.ParseInt(p, 10, 64)
+		if err == nil && (ints[i] < min || ints[i] > max) {
+			err = fmt.Errorf("%d is out of range [%d, %d]", ints[i], min, max)
+		}
+		if err != nil {
+			f.fail(key, err)
+			return make([]int64, n)
+		}
+	}
+	return ints
+}
+
+// Entities reads a bracketed, space-separated list of entity IDs, which is
+// how fmt prints a []EntityReference.
+func (f *textFields) Entities(key string) []EntityReference {
+	v, ok := f.get(key, "entities")
+	if !ok {
+		return nil
+	}
+	if !strings.HasPrefix(v, "[") || !strings.HasSuffix(v, "]") {
+		f.fail(key, fmt.Errorf("expected [...], not %q", v))
+		return nil
+	}
+	var ids []EntityReference
+	for _, p := range strings.Fields(v[1 : len(v)-1]) {
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			f.fail(key, err)
+			return nil
+		}
+		ids = append(ids, EntityReference(n))
+	}
+	return ids
+}
+
+func (f *textFields) Text(key string) string {
+	v, ok := f.get(key, "text")
+	if !ok {
+		return ""
+	}
+	s, err := strconv.Unquote(v)
+	if err != nil {
+		f.fail(key, err)
+	}
+	return s
+}
+
+func (f *textFields) Bytes(key string) []byte {
+	v, ok := f.get(key, "bytes")
+	if !ok {
+		return nil
+	}
+	b, err := hex.DecodeString(v)
+	if err != nil {
+		f.fail(key, err)
+	}
+	return b
+}
+
+// Done returns the first error any getter ran into, or an error naming a
+// field nobody asked for.
+func (f *textFields) Done() error {
+	if f.err != nil {
+		return f.err
+	}
+	for _, key := range f.order {
+		if v, ok := f.fields[key]; ok {
+			return fmt.Errorf("%s: unexpected field %s[%s]", f.Tag, key, v.Type)
+		}
+	}
+	return nil
+}
@@ -1,8 +1,19 @@
 package main
 
+import (
+	"encoding/binary"
+	"github.com/BenLubar/untitled-game/language"
+)
+
 // Timestamp 0 is the "nil time".
 type Timestamp uint64
 
+func (t Timestamp) bytes() []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t))
+	return b
+}
+
 const (
 	ts_ticks_per_day  Timestamp = 65535
 	ts_days_per_year  Timestamp = 641
@@ -58,18 +69,8 @@ const (
 	timeOfDay_max
 )
 
-var timeOfDayNames [timeOfDay_max]string = [...]string{
-	"N/A",
-
-	"night",
-	"dawn",
-	"morning",
-	"afternoon",
-	"dusk",
-}
-
 func (tod TimeOfDay) String() string {
-	return timeOfDayNames[tod]
+	return language.TimeOfDay(int(tod))
 }
 
 func (t Timestamp) TimeOfDay() TimeOfDay {
@@ -108,33 +109,42 @@ const (
 	season_max
 )
 
-var seasonNames [season_max]string = [...]string{
-	"N/A",
-
-	"the thaw",
-	"early spring",
-	"midspring",
-	"late spring",
-
-	"the burn",
-	"early summer",
-	"midsummer",
-	"late summer",
-
-	"the fall",
-	"early autumn",
-	"midautumn",
-	"late autumn",
-
-	"the freeze",
-	"early winter",
-	"midwinter",
-	"late winter",
-	"year's end",
+func (s Season) String() string {
+	return language.Season(int(s))
 }
 
-func (s Season) String() string {
-	return seasonNames[s]
+// seasonStart is the Day each season starts on. The thaw, the burn, the
+// fall, the freeze and year's end are one day long.
+var seasonStart = [season_max]uint64{
+	Season_TheThaw:     1,
+	Season_EarlySpring: 2,
+	Season_MidSpring:   53 + 2,
+	Season_LateSpring:  53*2 + 2,
+	Season_TheBurn:     53*3 + 2,
+	Season_EarlySummer: 53*3 + 3,
+	Season_MidSummer:   53*4 + 3,
+	Season_LateSummer:  53*5 + 3,
+	Season_TheFall:     53*6 + 3,
+	Season_EarlyAutumn: 53*6 + 4,
+	Season_MidAutumn:   53*7 + 4,
+	Season_LateAutumn:  53*8 + 4,
+	Season_TheFreeze:   53*9 + 4,
+	Season_EarlyWinter: 53*9 + 5,
+	Season_MidWinter:   53*10 + 5,
+	Season_LateWinter:  53*11 + 5,
+	Season_YearsEnd:    53*12 + 5,
+}
+
+// Days returns how many days long the season is.
+func (s Season) Days() uint64 {
+	switch s {
+	case Season_NA:
+		return 0
+	case Season_YearsEnd:
+		return uint64(ts_days_per_year) - seasonStart[s] + 1
+	default:
+		return seasonStart[s+1] - seasonStart[s]
+	}
 }
 
 func (t Timestamp) Season() Season {
@@ -179,3 +189,24 @@ func (t Timestamp) Season() Season {
 		return Season_YearsEnd
 	}
 }
+
+// DayOfSeason returns the day of the season, starting at 1.
+func (t Timestamp) DayOfSeason() uint64 {
+	if t == 0 {
+		return 0
+	}
+	return t.Day() - seasonStart[t.Season()] + 1
+}
+
+// Date returns the full date and time of day in the game's language.
+func (t Timestamp) Date() string {
+	if t == 0 {
+		return Season_NA.String()
+	}
+	season := t.Season()
+	day := int(t.DayOfSeason())
+	if season.Days() == 1 {
+		day = 0
+	}
+	return language.Date(day, int(season), int(t.Year()), int(t.TimeOfDay()))
+}
@@ -0,0 +1,549 @@
+// Command check-save reads saves and reports anything in them the game would
+// choke on: chunks and entities that do not decode, entity references that
+// point nowhere, an entity ID counter that is behind the stored entities,
+// and a location index that does not match them. References to destroyed
+// entities, which are kept in the tombstone collection, are fine. With
+// -repair, it also fixes what it can.
+//
+// This tool cannot import the game, so the types below mirror save version 3
+// and must be kept in sync with it.
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/gob"
+	"flag"
+	"fmt"
+	"github.com/steveyen/gkvlite"
+	"os"
+)
+
+var repair = flag.Bool("repair", false, "fix the problems that are found")
+
+const saveVersion = 3
+
+const chunkSize = 1 << 8
+
+type chunkCoord struct {
+	X, Y int64
+}
+
+type chunk struct {
+	ChunkCoord chunkCoord
+	Tiles      [chunkSize][chunkSize]struct {
+		Type uint8
+	}
+}
+
+type savedEntity struct {
+	ID         uint64
+	Components []savedComponent
+}
+
+type savedComponent struct {
+	Type    string
+	Version uint
+	Data    []byte
+}
+
+type locationComponent struct {
+	ID                     uint64
+	ChunkX, ChunkY, ChunkZ int64
+	TileX, TileY, TileZ    uint8
+}
+
+type ownerOfComponent struct {
+	ID uint64
+}
+
+type ownerComponent struct {
+	ID         uint64
+	Start, End uint64
+}
+
+type createdByComponent struct {
+	ID uint64
+}
+
+type createdComponent struct {
+	ID       uint64
+	Location uint64
+	Time     uint64
+	Material []uint64
+}
+
+func main() {
+	flag.Parse()
+
+	status := 0
+	for _, fn := range flag.Args() {
+		problems, err := check(fn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error on file %q: %v\n", fn, err)
+			status = 1
+		} else if problems != 0 && !*repair {
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
+
+type checker struct {
+	fn       string
+	problems int
+}
+
+func (c *checker) report(format string, args ...interface{}) {
+	c.problems++
+	fmt.Printf("%s: %s\n", c.fn, fmt.Sprintf(format, args...))
+}
+
+func check(fn string) (problems int, err error) {
+	mode := os.O_RDONLY
+	if *repair {
+		mode = os.O_RDWR
+	}
+	f, err := os.OpenFile(fn, mode, 0666)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err_ := f.Close()
+		if err == nil {
+			err = err_
+		}
+	}()
+
+	store, err := gkvlite.NewStore(f)
+	if err != nil {
+		return
+	}
+	defer store.Close()
+
+	c := &checker{fn: fn}
+
+	global := store.GetCollection("global")
+	if global == nil {
+		err = fmt.Errorf("no global collection; this is not a save")
+		return
+	}
+	version, err := getUint64(global, "version")
+	if err != nil {
+		return
+	}
+	if version != saveVersion {
+		err = fmt.Errorf("save version %d, but this tool reads version %d; load it in the game first", version, saveVersion)
+		return
+	}
+
+	if chunks := store.GetCollection("chunk"); chunks != nil {
+		err = c.checkChunks(chunks)
+		if err != nil {
+			return
+		}
+	}
+
+	stored := make(map[uint64]*savedEntity)
+	if entities := store.GetCollection("entity"); entities != nil {
+		stored, err = c.checkEntities(global, entities, store.GetCollection("tombstone"))
+		if err != nil {
+			return
+		}
+	}
+
+	index := store.GetCollection("location")
+	if index == nil {
+		index = store.SetCollection("location", nil)
+	}
+	err = c.checkLocationIndex(index, stored)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case c.problems == 0:
+		fmt.Printf("%s: ok\n", fn)
+	case *repair:
+		err = store.Flush()
+		if err == nil {
+			err = f.Sync()
+		}
+		fmt.Printf("%s: %d problems repaired\n", fn, c.problems)
+	default:
+		fmt.Printf("%s: %d problems; run with -repair to fix them\n", fn, c.problems)
+	}
+	return c.problems, err
+}
+
+func (c *checker) checkChunks(chunks *gkvlite.Collection) (err error) {
+	var bad [][]byte
+	misplaced := make(map[string]chunkCoord)
+
+	err = chunks.VisitItemsAscend(nil, true, func(i *gkvlite.Item) bool {
+		if len(i.Key) != 16 {
+			c.report("chunk key %x has the wrong length", i.Key)
+			bad = append(bad, i.Key)
+			return true
+		}
+		x, y := int64(binary.BigEndian.Uint64(i.Key[:8])), int64(binary.BigEndian.Uint64(i.Key[8:]))
+
+		var ch chunk
+		if err := bytesToObject(&ch, i.Val); err != nil {
+			c.report("chunk (%d, %d) does not decode: %v", x, y, err)
+			bad = append(bad, i.Key)
+			return true
+		}
+		if ch.ChunkCoord.X != x || ch.ChunkCoord.Y != y {
+			c.report("chunk (%d, %d) is stored under (%d, %d)", ch.ChunkCoord.X, ch.ChunkCoord.Y, x, y)
+			misplaced[string(i.Key)] = ch.ChunkCoord
+		}
+		return true
+	})
+	if err != nil || !*repair {
+		return
+	}
+
+	// Chunks that cannot be read are deleted, so the game generates them
+	// again. Misplaced chunks move to their own key, unless a chunk is
+	// already there.
+	for _, k := range bad {
+		if _, err = chunks.Delete(k); err != nil {
+			return
+		}
+	}
+	for from, coord := range misplaced {
+		var v, existing []byte
+		if v, err = chunks.Get([]byte(from)); err != nil {
+			return
+		}
+		if _, err = chunks.Delete([]byte(from)); err != nil {
+			return
+		}
+		to := chunkKey(coord)
+		if existing, err = chunks.Get(to); err != nil {
+			return
+		}
+		if existing == nil {
+			if err = chunks.Set(to, v); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// checkEntities returns the entities that are left once bad ones are
+// removed, as they are after repair.
+func (c *checker) checkEntities(global, entities, tombstones *gkvlite.Collection) (stored map[uint64]*savedEntity, err error) {
+	stored = make(map[uint64]*savedEntity)
+	destroyed := make(map[uint64]bool)
+	rewrite := make(map[uint64]bool)
+	var bad [][]byte
+	var maxID uint64
+
+	if tombstones != nil {
+		err = tombstones.VisitItemsAscend(nil, false, func(i *gkvlite.Item) bool {
+			if len(i.Key) == 8 {
+				id := binary.BigEndian.Uint64(i.Key)
+				destroyed[id] = true
+				if id > maxID {
+					maxID = id
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	err = entities.VisitItemsAscend(nil, true, func(i *gkvlite.Item) bool {
+		if len(i.Key) != 8 {
+			c.report("entity key %x has the wrong length", i.Key)
+			bad = append(bad, i.Key)
+			return true
+		}
+		id := binary.BigEndian.Uint64(i.Key)
+
+		var ent savedEntity
+		if err := bytesToObject(&ent, i.Val); err != nil {
+			c.report("entity %d does not decode: %v", id, err)
+			bad = append(bad, i.Key)
+			return true
+		}
+		if ent.ID != id {
+			c.report("entity %d is stored under %d", ent.ID, id)
+			ent.ID = id
+			rewrite[id] = true
+		}
+		stored[id] = &ent
+		if id > maxID {
+			maxID = id
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+
+	for _, ent := range stored {
+		var changed bool
+		if changed, err = c.checkReferences(ent, stored, destroyed); err != nil {
+			return
+		}
+		if changed {
+			rewrite[ent.ID] = true
+		}
+	}
+
+	next, err := getUint64(global, "entid")
+	if err != nil {
+		return
+	}
+	if next < maxID {
+		c.report("entid is %d, but entity %d exists", next, maxID)
+	}
+
+	if !*repair {
+		return
+	}
+
+	for _, k := range bad {
+		if _, err = entities.Delete(k); err != nil {
+			return
+		}
+	}
+	for id := range rewrite {
+		var b []byte
+		b, err = objectToBytes(stored[id])
+		if err != nil {
+			return
+		}
+		if err = entities.Set(uint64Key(id), b); err != nil {
+			return
+		}
+	}
+	if next < maxID {
+		err = global.Set([]byte("entid"), uint64Key(maxID))
+	}
+	return
+}
+
+// checkReferences reports references to entities that are neither stored
+// nor destroyed and returns true if the entity needs to be written back.
+// When repairing, references to missing entities are cleared, and components
+// that only exist to hold such a reference are removed.
+func (c *checker) checkReferences(ent *savedEntity, stored map[uint64]*savedEntity, destroyed map[uint64]bool) (changed bool, err error) {
+	dangling := func(what string, id uint64) bool {
+		if id == 0 || stored[id] != nil || destroyed[id] {
+			return false
+		}
+		c.report("entity %d: %s refers to missing entity %d", ent.ID, what, id)
+		changed = true
+		return true
+	}
+
+	kept := ent.Components[:0]
+	for _, sc := range ent.Components {
+		if sc.Version != 1 {
+			// not a version this tool knows.
+			kept = append(kept, sc)
+			continue
+		}
+
+		var fixed interface{}
+		switch sc.Type {
+		case "LOCATION":
+			var l locationComponent
+			if err = decodeComponent(sc, &l); err == nil && dangling("LOCATION", l.ID) {
+				l.ID, fixed = 0, &l
+			}
+		case "OWNER_OF":
+			var o ownerOfComponent
+			if err = decodeComponent(sc, &o); err == nil && dangling("OWNER_OF", o.ID) {
+				continue
+			}
+		case "OWNER":
+			var o ownerComponent
+			if err = decodeComponent(sc, &o); err == nil && dangling("OWNER", o.ID) {
+				continue
+			}
+		case "CREATED_BY":
+			var cb createdByComponent
+			if err = decodeComponent(sc, &cb); err == nil && dangling("CREATED_BY", cb.ID) {
+				continue
+			}
+		case "CREATED":
+			var cr createdComponent
+			if err = decodeComponent(sc, &cr); err != nil {
+				break
+			}
+			if dangling("CREATED creator", cr.ID) {
+				cr.ID, fixed = 0, &cr
+			}
+			if dangling("CREATED location", cr.Location) {
+				cr.Location, fixed = 0, &cr
+			}
+			material := cr.Material[:0]
+			for _, m := range cr.Material {
+				if dangling("CREATED material", m) {
+					fixed = &cr
+				} else {
+					material = append(material, m)
+				}
+			}
+			cr.Material = material
+		}
+		if err != nil {
+			c.report("entity %d: %s component does not decode: %v", ent.ID, sc.Type, err)
+			err = nil
+		}
+
+		if fixed != nil {
+			var buf bytes.Buffer
+			if err = gob.NewEncoder(&buf).Encode(fixed); err != nil {
+				return
+			}
+			sc.Data = buf.Bytes()
+		}
+		kept = append(kept, sc)
+	}
+	ent.Components = kept
+	return
+}
+
+// checkLocationIndex compares the location index with the LOCATION
+// components of the stored entities. When repairing, the index is rebuilt
+// from the entities.
+func (c *checker) checkLocationIndex(index *gkvlite.Collection, stored map[uint64]*savedEntity) (err error) {
+	want := make(map[string][]byte)
+	for id, ent := range stored {
+		// the game indexes the first LOCATION component.
+		for _, sc := range ent.Components {
+			if sc.Type != "LOCATION" {
+				continue
+			}
+			var l locationComponent
+			if sc.Version == 1 && decodeComponent(sc, &l) == nil {
+				k := locationIndexKey(id, &l)
+				want[string(k)] = []byte{l.TileX, l.TileY, l.TileZ}
+				want[string(locationIndexEntityKey(id))] = k
+			}
+			break
+		}
+	}
+
+	var have [][]byte
+	seen := make(map[string]bool)
+	err = index.VisitItemsAscend(nil, true, func(i *gkvlite.Item) bool {
+		have = append(have, i.Key)
+		v, ok := want[string(i.Key)]
+		switch {
+		case !ok:
+			c.report("location index: stale entry %x", i.Key)
+		case !bytes.Equal(v, i.Val):
+			c.report("location index: wrong value for entry %x", i.Key)
+		}
+		seen[string(i.Key)] = true
+		return true
+	})
+	if err != nil {
+		return
+	}
+	for k := range want {
+		if !seen[k] {
+			c.report("location index: missing entry %x", k)
+		}
+	}
+	if !*repair {
+		return
+	}
+
+	for _, k := range have {
+		if _, err = index.Delete(k); err != nil {
+			return
+		}
+	}
+	for k, v := range want {
+		if err = index.Set([]byte(k), v); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func decodeComponent(sc savedComponent, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(sc.Data)).Decode(v)
+}
+
+func chunkKey(coord chunkCoord) []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b[0:8], uint64(coord.X))
+	binary.BigEndian.PutUint64(b[8:16], uint64(coord.Y))
+	return b
+}
+
+// locationIndexKey and locationIndexEntityKey are copied from the game.
+
+func locationIndexKey(id uint64, l *locationComponent) []byte {
+	b := make([]byte, 1+8+8+8+8)
+	b[0] = 'c'
+	binary.BigEndian.PutUint64(b[1:], uint64(l.ChunkX))
+	binary.BigEndian.PutUint64(b[9:], uint64(l.ChunkY))
+	binary.BigEndian.PutUint64(b[17:], uint64(l.ChunkZ))
+	binary.BigEndian.PutUint64(b[25:], id)
+	return b
+}
+
+func locationIndexEntityKey(id uint64) []byte {
+	return append([]byte{'e'}, uint64Key(id)...)
+}
+
+func uint64Key(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+func getUint64(c *gkvlite.Collection, key string) (uint64, error) {
+	b, err := c.Get([]byte(key))
+	if err != nil {
+		return 0, err
+	}
+	tmp := make([]byte, 8)
+	copy(tmp, b)
+	return binary.BigEndian.Uint64(tmp), nil
+}
+
+// objectToBytes and bytesToObject are copied from the game.
+
+func objectToBytes(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	f, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	err = gob.NewEncoder(f).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	err = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func bytesToObject(v interface{}, b []byte) error {
+	f := flate.NewReader(bytes.NewReader(b))
+	defer f.Close()
+
+	err := gob.NewDecoder(f).Decode(v)
+	if err != nil {
+		// try the old, uncompressed format.
+		err = gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+	}
+	return err
+}
@@ -1,62 +1,218 @@
 package main
 
 import (
+	"bytes"
+	"compress/flate"
+	"encoding/gob"
 	"flag"
-	"os"
-	"io/ioutil"
-	"github.com/steveyen/gkvlite"
 	"fmt"
+	"github.com/steveyen/gkvlite"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+var (
+	dryRun   = flag.Bool("dry-run", false, "report what would change without replacing any save")
+	reencode = flag.Bool("reencode", false, "rewrite chunks and entities saved in the old uncompressed format")
 )
 
+// collections whose values are written by objectToBytes in the game.
+var encodedCollections = []string{"chunk", "entity"}
+
 func main() {
 	flag.Parse()
 
+	status := 0
 	for _, fn := range flag.Args() {
 		err := compress(fn)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error on file %q: %v", fn, err)
+			fmt.Fprintf(os.Stderr, "Error on file %q: %v\n", fn, err)
+			status = 1
 		}
 	}
+	os.Exit(status)
 }
 
+// compress copies the store in fn to a new file in the same directory,
+// which leaves out everything gkvlite has appended and no longer uses. The
+// copy is synced to disk and then renamed over fn, so fn is always either
+// the old save or the new one.
 func compress(fn string) (err error) {
-	f, err := os.OpenFile(fn, os.O_RDWR, 0666)
+	f, err := os.Open(fn)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		err_ := f.Close()
-		if err == nil {
-			err = err_
-		}
-	}()
+	// f is closed before the rename below; this covers the early returns.
+	defer f.Close()
 
-	tmpf, err := ioutil.TempFile("", "gkvlite")
+	before, err := f.Stat()
 	if err != nil {
 		return err
 	}
-	defer os.Remove(tmpf.Name())
-	defer tmpf.Close()
 
 	store, err := gkvlite.NewStore(f)
 	if err != nil {
 		return err
 	}
 
+	beforeCounts, err := countItems(store)
+	if err != nil {
+		return err
+	}
+
+	legacy := 0
+	if *reencode {
+		// Changes are made to the in-memory store only and never flushed
+		// to fn; CopyTo writes them to the new file.
+		legacy, err = reencodeLegacy(store)
+		if err != nil {
+			return err
+		}
+	}
+
+	tmpf, err := ioutil.TempFile(filepath.Dir(fn), filepath.Base(fn)+".compress-")
+	if err != nil {
+		return err
+	}
+	tmpName := tmpf.Name()
+	renamed := false
+	defer func() {
+		if !renamed {
+			os.Remove(tmpName)
+		}
+	}()
+	defer tmpf.Close()
+
 	store2, err := store.CopyTo(tmpf, 1000000)
 	if err != nil {
 		return err
 	}
 
-	err = f.Truncate(0)
+	afterCounts, err := countItems(store2)
 	if err != nil {
 		return err
 	}
+	names := store.GetCollectionNames()
+	store2.Close()
+	store.Close()
 
-	_, err = store2.CopyTo(f, 1000000)
+	if err = tmpf.Sync(); err != nil {
+		return err
+	}
+	after, err := tmpf.Stat()
 	if err != nil {
 		return err
 	}
 
+	fmt.Printf("%s: %d bytes -> %d bytes\n", fn, before.Size(), after.Size())
+	for _, name := range names {
+		fmt.Printf("%s:\t%s: %d items -> %d items\n", fn, name, beforeCounts[name], afterCounts[name])
+	}
+	if *reencode {
+		fmt.Printf("%s:\t%d values re-encoded\n", fn, legacy)
+	}
+	for name, n := range beforeCounts {
+		if afterCounts[name] != n {
+			return fmt.Errorf("collection %q has %d items after copying, but %d before", name, afterCounts[name], n)
+		}
+	}
+
+	if *dryRun {
+		return nil
+	}
+
+	if err = tmpf.Close(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpName, before.Mode()); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpName, fn); err != nil {
+		return err
+	}
+	renamed = true
+
+	// make the rename itself durable.
+	if dir, err := os.Open(filepath.Dir(fn)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
 	return nil
 }
+
+func countItems(store *gkvlite.Store) (map[string]uint64, error) {
+	counts := make(map[string]uint64)
+	for _, name := range store.GetCollectionNames() {
+		var n uint64
+		err := store.GetCollection(name).VisitItemsAscend(nil, false, func(*gkvlite.Item) bool {
+			n++
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		counts[name] = n
+	}
+	return counts, nil
+}
+
+// reencodeLegacy compresses the values objectToBytes wrote before saves
+// were compressed. Those values are plain gob, and the current format is the
+// same gob stream run through flate, so no types need to be known.
+func reencodeLegacy(store *gkvlite.Store) (count int, err error) {
+	for _, name := range encodedCollections {
+		c := store.GetCollection(name)
+		if c == nil {
+			continue
+		}
+
+		var legacy []*gkvlite.Item
+		err = c.VisitItemsAscend(nil, true, func(i *gkvlite.Item) bool {
+			if !isCompressedGob(i.Val) && isGob(i.Val) {
+				legacy = append(legacy, i)
+			}
+			return true
+		})
+		if err != nil {
+			return
+		}
+
+		for _, i := range legacy {
+			var buf bytes.Buffer
+			var w *flate.Writer
+			w, err = flate.NewWriter(&buf, flate.BestCompression)
+			if err != nil {
+				return
+			}
+			if _, err = w.Write(i.Val); err != nil {
+				return
+			}
+			if err = w.Close(); err != nil {
+				return
+			}
+			if err = c.Set(i.Key, buf.Bytes()); err != nil {
+				return
+			}
+			count++
+		}
+	}
+	return
+}
+
+// isCompressedGob and isGob check that b holds a complete gob value of any
+// type, which DecodeValue skips when given the zero reflect.Value.
+func isCompressedGob(b []byte) bool {
+	f := flate.NewReader(bytes.NewReader(b))
+	defer f.Close()
+
+	return gob.NewDecoder(f).DecodeValue(reflect.Value{}) == nil
+}
+
+func isGob(b []byte) bool {
+	return gob.NewDecoder(bytes.NewReader(b)).DecodeValue(reflect.Value{}) == nil
+}
@@ -3,32 +3,50 @@ package main
 import (
 	"bytes"
 	"compress/flate"
+	"container/list"
 	"encoding/binary"
 	"encoding/gob"
 	"fmt"
 	"github.com/BenLubar/untitled-game/simplex"
-	"github.com/steveyen/gkvlite"
 	"log"
 	"math/rand"
-	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const CurrentSaveVersion = 1
+const CurrentSaveVersion = 3
 
 type World struct {
+	// time is the world clock, accessed with sync/atomic. It is first so
+	// that it is 64-bit aligned.
+	time uint64
+
 	chunks   map[ChunkCoord]*Chunk
+	retained *list.List // of *Chunk, most recently released first
 	entities map[EntityReference]*Entity
 
-	storeFile *os.File
-
-	store  *gkvlite.Store
-	global *gkvlite.Collection
-	chunk  *gkvlite.Collection
-	entity *gkvlite.Collection
+	store     Backend
+	global    Collection
+	chunk     Collection
+	entity    Collection
+	location  Collection // see location_index.go
+	tombstone Collection // see destroy.go
+	history   Collection // see history.go
+	schedule  Collection // see schedule.go
 
 	simplex *simplex.Simplex
 
+	// name of the save, as shown in the main menu.
+	name string
+
+	playerX, playerY int64
+	playStart        time.Time // when play time was last added to SaveMeta
+
+	clock       clock
+	saveStatus  saveStatus
+	systemStats systemStats
+
 	sync.Mutex
 }
 
@@ -44,17 +62,75 @@ func GetWorld() *World {
 	return world
 }
 
+// NewWorld generates a new world from seed in store, which should be empty.
+func NewWorld(store Backend, seed string) (w *World, err error) {
+	w = &World{store: store, clock: clock{speed: gameSpeeds[0]}}
+
+	err = w.setSeed(NewSeed(seed))
+	if err == nil {
+		err = w.init()
+	}
+	if err != nil {
+		w = nil
+	}
+	return
+}
+
+// LoadWorld opens the world saved in store, migrating it to the current save
+// version if needed.
+func LoadWorld(store Backend) (w *World, err error) {
+	w = &World{store: store, clock: clock{speed: gameSpeeds[0]}}
+
+	err = w.init()
+	if err != nil {
+		w = nil
+	}
+	return
+}
+
 var kTime = []byte("time")
 
+// Time returns the world clock. It does not need w's lock.
 func (w *World) Time() Timestamp {
+	return Timestamp(atomic.LoadUint64(&w.time))
+}
+
+// setTime moves the world clock. The caller must hold w's lock.
+func (w *World) setTime(t Timestamp) {
+	atomic.StoreUint64(&w.time, uint64(t))
+}
+
+// SetTime moves the world clock to t, backwards or forwards. The systems do
+// not run for the ticks in between, but scheduled events that are now due
+// run, in order, on the next Tick.
+func (w *World) SetTime(t Timestamp) error {
+	w.Lock()
+	defer w.Unlock()
+
+	w.setTime(t)
+	return nil
+}
+
+// loadTime reads the world clock from the store. It is only written back by
+// writeMeta, so ticking does not touch the store.
+func (w *World) loadTime() (err error) {
 	t, err := w.global.Get(kTime)
 	if err != nil {
-		panic(err)
+		return
 	}
-	if len(t) != 8 {
-		return Timestamp(0)
+	if len(t) == 8 {
+		w.setTime(Timestamp(binary.BigEndian.Uint64(t)))
 	}
-	return Timestamp(binary.BigEndian.Uint64(t))
+	return
+}
+
+// now is Time, except that it is never the nil time, so it can be used to
+// end OwnerComponent records and the like.
+func (w *World) now() Timestamp {
+	if t := w.Time(); t != 0 {
+		return t
+	}
+	return ts_min
 }
 
 var kSeed = []byte("seed")
@@ -100,7 +176,7 @@ func (w *World) setSeed(seed *Seed) (err error) {
 	// This is ok here, but nowhere else. This function is the only one that
 	// can be called on an uninitialized world.
 	if w.global == nil {
-		w.global = w.store.SetCollection("global", nil)
+		w.global = w.store.Collection("global")
 	}
 
 	err = w.global.Set(kSeed, buf.Bytes())
@@ -162,24 +238,22 @@ func (w *World) NewEntity() (ent *Entity, err error) {
 	w.Lock()
 	defer w.Unlock()
 
-	id_, err := w.global.Get(kNextEntityID)
+	return w.newEntity()
+}
+
+func (w *World) newEntity() (ent *Entity, err error) {
+	n, err := w.nextCounter(kNextEntityID)
 	if err != nil {
 		return
 	}
+	id := EntityReference(n)
 
-	id := EntityReference(1)
-	if len(id_) == 8 {
-		id = EntityReference(binary.BigEndian.Uint64(id_) + 1)
-	}
-
-	id_ = make([]byte, 8)
-	binary.BigEndian.PutUint64(id_, uint64(id))
-	err = w.global.Set(kNextEntityID, id_)
+	err = w.recordEvent(EventCreated, id)
 	if err != nil {
 		return
 	}
 
-	ent = &Entity{ID: id}
+	ent = &Entity{ID: id, dirty: true}
 	ent.references++
 	if w.entities == nil {
 		w.entities = make(map[EntityReference]*Entity)
@@ -188,10 +262,35 @@ func (w *World) NewEntity() (ent *Entity, err error) {
 	return
 }
 
+// nextCounter adds one to the counter stored under key in the global
+// collection and returns the new value. A counter that has never been set
+// starts at 0. The caller must hold w's lock.
+func (w *World) nextCounter(key []byte) (n uint64, err error) {
+	b, err := w.global.Get(key)
+	if err != nil {
+		return
+	}
+	if len(b) == 8 {
+		n = binary.BigEndian.Uint64(b)
+	}
+	n++
+	b = make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	err = w.global.Set(key, b)
+	return
+}
+
+// RequestEntity returns the entity with the given ID, which must be released
+// with ReleaseEntity. It returns ErrEntityDestroyed if the entity has been
+// destroyed.
 func (w *World) RequestEntity(id EntityReference) (ent *Entity, err error) {
 	w.Lock()
 	defer w.Unlock()
 
+	return w.requestEntity(id)
+}
+
+func (w *World) requestEntity(id EntityReference) (ent *Entity, err error) {
 	if ent = w.entities[id]; ent != nil {
 		ent.references++
 		return ent, nil
@@ -201,8 +300,21 @@ func (w *World) RequestEntity(id EntityReference) (ent *Entity, err error) {
 	if err != nil {
 		return
 	}
+	if v == nil {
+		if v, err = w.tombstone.Get(id.bytes()); err == nil {
+			if v != nil {
+				err = ErrEntityDestroyed
+			} else {
+				err = fmt.Errorf("entity %d does not exist", id)
+			}
+		}
+		return
+	}
 
-	err = bytesToObject(&ent, v)
+	ent, err = decodeEntity(v)
+	if err == nil {
+		err = w.clearDestroyedContainer(ent)
+	}
 	if err != nil {
 		ent = nil
 	} else {
@@ -219,6 +331,10 @@ func (w *World) ReleaseEntity(ent *Entity) {
 	w.Lock()
 	defer w.Unlock()
 
+	w.releaseEntity(ent)
+}
+
+func (w *World) releaseEntity(ent *Entity) {
 	if ent == nil {
 		panic("release of nil entity")
 	}
@@ -232,12 +348,10 @@ func (w *World) ReleaseEntity(ent *Entity) {
 	}
 	ent.references--
 	if ent.references == 0 {
-		b, err := objectToBytes(ent)
-		if err != nil {
-			panic(err)
-		}
-		if err = w.entity.Set(ent.ID.bytes(), b); err != nil {
-			panic(err)
+		if ent.dirty {
+			if err := w.storeEntity(ent); err != nil {
+				panic(err)
+			}
 		}
 		delete(w.entities, ent.ID)
 	}
@@ -248,6 +362,10 @@ func (w *World) RequestChunk(coord ChunkCoord) (c *Chunk, err error) {
 	defer w.Unlock()
 
 	if c = w.chunks[coord]; c != nil {
+		if c.retained != nil {
+			w.retained.Remove(c.retained)
+			c.retained = nil
+		}
 		c.references++
 		return c, nil
 	}
@@ -305,23 +423,70 @@ func (w *World) ReleaseChunk(c *Chunk) {
 	}
 	c.references--
 	if c.references == 0 {
-		b, err := objectToBytes(c)
-		if err != nil {
-			panic(err)
+		if c.dirty {
+			if err := w.storeChunk(c); err != nil {
+				panic(err)
+			}
 		}
-		if err = w.chunk.Set(c.ChunkCoord.bytes(), b); err != nil {
-			panic(err)
+		w.retainChunk(c)
+	}
+}
+
+// storeChunk writes c to the store and marks it clean. The caller must hold
+// w's lock and either hold c's write lock or be its only user.
+func (w *World) storeChunk(c *Chunk) (err error) {
+	b, err := objectToBytes(c)
+	if err != nil {
+		return
+	}
+	if err = w.chunk.Set(c.ChunkCoord.bytes(), b); err != nil {
+		return
+	}
+	c.dirty = false
+	return
+}
+
+// maxRetainedChunks is the number of unreferenced chunks kept decoded in
+// memory, so walking back and forth over a chunk border does not decode the
+// same chunks over and over.
+const maxRetainedChunks = 64
+
+// retainChunk keeps an unreferenced chunk in the cache, evicting the least
+// recently released chunks beyond maxRetainedChunks. Evicted chunks that
+// were marked dirty after they were released are written first.
+func (w *World) retainChunk(c *Chunk) {
+	if w.retained == nil {
+		w.retained = list.New()
+	}
+	c.retained = w.retained.PushFront(c)
+
+	for w.retained.Len() > maxRetainedChunks {
+		old := w.retained.Remove(w.retained.Back()).(*Chunk)
+		old.retained = nil
+		if old.dirty {
+			if err := w.storeChunk(old); err != nil {
+				panic(err)
+			}
 		}
-		delete(w.chunks, c.ChunkCoord)
+		delete(w.chunks, old.ChunkCoord)
 	}
 }
 
 var kVersion = []byte("version")
 
 func (w *World) init() (err error) {
-	w.global = w.store.SetCollection("global", nil)
-	w.chunk = w.store.SetCollection("chunk", nil)
-	w.entity = w.store.SetCollection("entity", nil)
+	w.global = w.store.Collection("global")
+	w.chunk = w.store.Collection("chunk")
+	w.entity = w.store.Collection("entity")
+	w.location = w.store.Collection("location")
+	w.tombstone = w.store.Collection("tombstone")
+	w.history = w.store.Collection("history")
+	w.schedule = w.store.Collection("schedule")
+
+	if err = w.loadTime(); err != nil {
+		log.Printf("error getting time: %v", err)
+		return
+	}
 
 	versionBuf, err := w.global.Get(kVersion)
 	if err != nil {
@@ -337,8 +502,8 @@ func (w *World) init() (err error) {
 
 	version := binary.BigEndian.Uint64(versionBuf)
 
-	switch version {
-	case 0:
+	switch {
+	case version == 0:
 		for cx := int64(-16); cx <= 16; cx++ {
 			for cy := int64(-16); cy <= 16; cy++ {
 				c, err := w.RequestChunk(ChunkCoord{cx, cy})
@@ -350,17 +515,28 @@ func (w *World) init() (err error) {
 			}
 		}
 
+		err = w.setMeta(&SaveMeta{Created: time.Now()})
+		if err != nil {
+			return err
+		}
+
 		binary.BigEndian.PutUint64(versionBuf, CurrentSaveVersion)
 		err = w.global.Set(kVersion, versionBuf)
 		if err != nil {
 			return err
 		}
 
-	case CurrentSaveVersion:
+	case version == CurrentSaveVersion:
 		// no updates
 
+	case version < CurrentSaveVersion:
+		err = w.migrate(version)
+		if err != nil {
+			return err
+		}
+
 	default:
-		return fmt.Errorf("unexpected version: %d", version)
+		return fmt.Errorf("save version %d is newer than this game supports (%d)", version, CurrentSaveVersion)
 	}
 
 	for i := int64(-1); i <= int64(1); i++ {
@@ -369,6 +545,15 @@ func (w *World) init() (err error) {
 		}
 	}
 
+	err = w.loadMeta()
+	if err != nil {
+		return err
+	}
+	err = w.writeMeta()
+	if err != nil {
+		return err
+	}
+
 	return w.store.Flush()
 }
 
@@ -400,7 +585,3 @@ func bytesToObject(v interface{}, b []byte) error {
 	}
 	return err
 }
-
-func (w *World) Tick() {
-	// TODO: game ticks
-}
@@ -0,0 +1,83 @@
+package main
+
+import "testing"
+
+// TestWorldMemoryBackend saves a world to a memory backend and loads it
+// back, checking that entities, tiles, the clock and the history index all
+// survive.
+func TestWorldMemoryBackend(t *testing.T) {
+	store := NewMemoryBackend()
+	w, err := NewWorld(store, "memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ent, err := w.NewEntity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := ent.ID
+	ent.SetLocation(LocationComponent{ChunkX: 2, ChunkY: -1, TileX: 3, TileY: 4})
+	w.ReleaseEntity(ent)
+
+	c, err := w.RequestChunk(ChunkCoord{2, -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetTile(3, 4, Tile{Type: TileWater})
+	w.ReleaseChunk(c)
+
+	if err = w.SetTime(ts_min + 42); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = LoadWorld(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Time(); got != ts_min+42 {
+		t.Errorf("time: got %v, want %v", got, ts_min+42)
+	}
+
+	ent, err = w.RequestEntity(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l, ok := ent.Location(); !ok || l.ChunkX != 2 || l.ChunkY != -1 || l.TileX != 3 || l.TileY != 4 {
+		t.Errorf("location: got %+v, %v", l, ok)
+	}
+	w.ReleaseEntity(ent)
+
+	if ids, err := w.EntitiesInChunk(ChunkCoord{2, -1}); err != nil || len(ids) != 1 || ids[0] != id {
+		t.Errorf("entities in chunk: got %v, %v", ids, err)
+	}
+
+	c, err = w.RequestChunk(ChunkCoord{2, -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Tiles[3][4].Type; got != TileWater {
+		t.Errorf("tile: got %v, want %v", got, TileWater)
+	}
+	w.ReleaseChunk(c)
+
+	// the history index is made of empty values, which must not read back
+	// as missing.
+	if events, err := w.EntityHistory(id, 0, 0); err != nil || len(events) != 1 || events[0].Type != EventCreated {
+		t.Errorf("history: got %v, %v", events, err)
+	}
+	var key []byte
+	err = w.history.Visit(historyEntityKey(id, 0, 0), func(k, v []byte) bool {
+		key = k
+		return false
+	})
+	if err != nil || key == nil {
+		t.Fatalf("history index: got %x, %v", key, err)
+	}
+	if v, err := w.history.Get(key); err != nil || v == nil || len(v) != 0 {
+		t.Errorf("history index value: got %#v, %v", v, err)
+	}
+}